@@ -0,0 +1,656 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const activityJSONType = "application/activity+json"
+
+// UserKeys holds the RSA keypair Finch generates for a user so its posts
+// can be signed as ActivityPub activities.
+type UserKeys struct {
+	UserId     int
+	PublicKey  string // PEM encoded
+	PrivateKey string // PEM encoded
+}
+
+// RemoteUser is a cached copy of an Actor fetched from another server.
+type RemoteUser struct {
+	Id           int
+	ActorId      string
+	Inbox        string
+	SharedInbox  string
+	Handle       string
+	PublicKeyPem string
+}
+
+type apActor struct {
+	Context           interface{}      `json:"@context"`
+	Id                string           `json:"id"`
+	Type              string           `json:"type"`
+	PreferredUsername string           `json:"preferredUsername"`
+	Inbox             string           `json:"inbox"`
+	Outbox            string           `json:"outbox"`
+	Followers         string           `json:"followers"`
+	PublicKey         apActorPublicKey `json:"publicKey"`
+}
+
+type apActorPublicKey struct {
+	Id           string `json:"id"`
+	Owner        string `json:"owner"`
+	PublicKeyPem string `json:"publicKeyPem"`
+}
+
+type apActivity struct {
+	Context interface{} `json:"@context"`
+	Id      string      `json:"id,omitempty"`
+	Type    string      `json:"type"`
+	Actor   string      `json:"actor"`
+	Object  interface{} `json:"object,omitempty"`
+	To      []string    `json:"to,omitempty"`
+}
+
+type apNote struct {
+	Id           string `json:"id"`
+	Type         string `json:"type"`
+	AttributedTo string `json:"attributedTo"`
+	Content      string `json:"content"`
+	Published    string `json:"published"`
+}
+
+func actorId(baseUrl, username string) string {
+	return strings.TrimRight(baseUrl, "/") + "/u/" + username
+}
+
+// webfingerHandler implements the bare minimum of RFC 7033 needed for
+// other ActivityPub servers to discover a Finch user's actor URL.
+func webfingerHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	resource := r.URL.Query().Get("resource")
+	if !strings.HasPrefix(resource, "acct:") {
+		http.Error(w, "bad resource", http.StatusBadRequest)
+		return
+	}
+	acct := strings.TrimPrefix(resource, "acct:")
+	parts := strings.SplitN(acct, "@", 2)
+	username := parts[0]
+
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	resp := map[string]interface{}{
+		"subject": resource,
+		"links": []map[string]string{
+			{
+				"rel":  "self",
+				"type": activityJSONType,
+				"href": actorId(s.baseUrl, u.Username),
+			},
+		},
+	}
+	w.Header().Set("Content-Type", "application/jrd+json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// userOrActorDispatch sits in front of the plain HTML userDispatch on
+// /u/: requests for /u/<username>/inbox or /outbox are always treated as
+// ActivityPub, and a bare /u/<username> is served as an Actor document
+// when the client asks for activity+json instead of HTML.
+func userOrActorDispatch(w http.ResponseWriter, r *http.Request, s *site) {
+	username := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/u/"), "/")
+
+	switch {
+	case strings.HasSuffix(username, "/inbox"):
+		apInboxHandler(w, r, s, strings.TrimSuffix(username, "/inbox"))
+	case strings.HasSuffix(username, "/outbox"):
+		apOutboxHandler(w, r, s, strings.TrimSuffix(username, "/outbox"))
+	case wantsActivityJSON(r):
+		apActorHandler(w, r, s, username)
+	default:
+		userDispatch(w, r, s)
+	}
+}
+
+// wantsActivityJSON reports whether the client's Accept header is asking
+// for an ActivityPub document rather than the HTML profile page.
+func wantsActivityJSON(r *http.Request) bool {
+	accept := r.Header.Get("Accept")
+	return strings.Contains(accept, activityJSONType) || strings.Contains(accept, "application/ld+json")
+}
+
+// apActorHandler serves the Actor document for a user. It's consulted by
+// userOrActorDispatch when the request asks for activity+json instead of
+// HTML.
+func apActorHandler(w http.ResponseWriter, r *http.Request, s *site, username string) {
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	keys, err := s.persistence.GetUserKeys(u.Id)
+	if err != nil {
+		log.Println("error loading keys for actor", username, err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	id := actorId(s.baseUrl, username)
+	actor := apActor{
+		Context: []string{
+			"https://www.w3.org/ns/activitystreams",
+			"https://w3id.org/security/v1",
+		},
+		Id:                id,
+		Type:              "Person",
+		PreferredUsername: username,
+		Inbox:             id + "/inbox",
+		Outbox:            id + "/outbox",
+		Followers:         id + "/followers",
+		PublicKey: apActorPublicKey{
+			Id:           id + "#main-key",
+			Owner:        id,
+			PublicKeyPem: keys.PublicKey,
+		},
+	}
+	w.Header().Set("Content-Type", activityJSONType)
+	json.NewEncoder(w).Encode(actor)
+}
+
+func apOutboxHandler(w http.ResponseWriter, r *http.Request, s *site, username string) {
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	posts, err := s.persistence.GetAllUserPosts(r.Context(), u, s.itemsPerPage, 0)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	id := actorId(s.baseUrl, username)
+	items := make([]apActivity, 0, len(posts))
+	for _, p := range posts {
+		items = append(items, createActivityForPost(s.baseUrl, u, p))
+	}
+	resp := map[string]interface{}{
+		"@context":     "https://www.w3.org/ns/activitystreams",
+		"id":           id + "/outbox",
+		"type":         "OrderedCollection",
+		"totalItems":   len(items),
+		"orderedItems": items,
+	}
+	w.Header().Set("Content-Type", activityJSONType)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func createActivityForPost(baseUrl string, u *User, p *Post) apActivity {
+	aid := actorId(baseUrl, u.Username)
+	noteId := fmt.Sprintf("%s/post/%d", strings.TrimRight(baseUrl, "/"), p.Id)
+	return apActivity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Id:      noteId + "/activity",
+		Type:    "Create",
+		Actor:   aid,
+		To:      []string{"https://www.w3.org/ns/activitystreams#Public"},
+		Object: apNote{
+			Id:           noteId,
+			Type:         "Note",
+			AttributedTo: aid,
+			Content:      p.Body,
+			Published:    time.Unix(int64(p.Posted), 0).UTC().Format(time.RFC3339),
+		},
+	}
+}
+
+// apInboxHandler accepts incoming federated activities: Follow, Undo{Follow},
+// Create{Note} and Delete. The sender's HTTP signature is verified before
+// anything is persisted.
+func apInboxHandler(w http.ResponseWriter, r *http.Request, s *site, username string) {
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "bad body", http.StatusBadRequest)
+		return
+	}
+
+	var act apActivity
+	if err := json.Unmarshal(body, &act); err != nil {
+		http.Error(w, "bad activity", http.StatusBadRequest)
+		return
+	}
+
+	remote, err := fetchRemoteActor(act.Actor)
+	if err != nil {
+		log.Println("error fetching remote actor", act.Actor, err)
+		http.Error(w, "unknown actor", http.StatusBadRequest)
+		return
+	}
+
+	if err := verifyHTTPSignature(r, body, remote.PublicKeyPem); err != nil {
+		log.Println("bad signature from", act.Actor, err)
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+
+	switch act.Type {
+	case "Follow":
+		handleFollow(s, u, remote, act)
+	case "Undo":
+		handleUndoFollow(s, u, remote, act)
+	case "Create":
+		handleRemoteCreate(s, u, act)
+	case "Delete":
+		handleRemoteDelete(s, act)
+	default:
+		log.Println("unhandled activity type", act.Type)
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func handleFollow(s *site, u *User, remote *RemoteUser, act apActivity) {
+	rid, err := s.persistence.AddRemoteUser(remote)
+	if err != nil {
+		log.Println("error storing remote user", err)
+		return
+	}
+	if err := s.persistence.AddFollower(u.Id, rid); err != nil {
+		log.Println("error recording follower", err)
+		return
+	}
+
+	accept := apActivity{
+		Context: "https://www.w3.org/ns/activitystreams",
+		Type:    "Accept",
+		Actor:   actorId(s.baseUrl, u.Username),
+		Object:  act,
+	}
+	enqueueDelivery(s, u, remote.Inbox, accept)
+}
+
+func handleUndoFollow(s *site, u *User, remote *RemoteUser, act apActivity) {
+	rid, err := s.persistence.AddRemoteUser(remote)
+	if err != nil {
+		log.Println("error storing remote user", err)
+		return
+	}
+	if err := s.persistence.RemoveFollower(u.Id, rid); err != nil {
+		log.Println("error removing follower", err)
+	}
+}
+
+func handleRemoteCreate(s *site, u *User, act apActivity) {
+	object, ok := act.Object.(map[string]interface{})
+	if !ok {
+		return
+	}
+	content, _ := object["content"].(string)
+	if content == "" {
+		return
+	}
+	objectId, _ := object["id"].(string)
+	if _, err := s.persistence.AddRemotePost(act.Actor, objectId, content); err != nil {
+		log.Println("error persisting remote post", err)
+	}
+}
+
+func handleRemoteDelete(s *site, act apActivity) {
+	var objectId string
+	switch obj := act.Object.(type) {
+	case string:
+		objectId = obj
+	case map[string]interface{}:
+		objectId, _ = obj["id"].(string)
+	}
+	if objectId == "" {
+		return
+	}
+	if err := s.persistence.DeleteRemotePost(objectId); err != nil {
+		log.Println("error deleting remote post", objectId, err)
+	}
+}
+
+func fetchRemoteActor(id string) (*RemoteUser, error) {
+	req, err := http.NewRequest("GET", id, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", activityJSONType)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching actor %s: %s", id, resp.Status)
+	}
+
+	var actor struct {
+		apActor
+		Endpoints struct {
+			SharedInbox string `json:"sharedInbox"`
+		} `json:"endpoints"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&actor); err != nil {
+		return nil, err
+	}
+
+	return &RemoteUser{
+		ActorId:      actor.Id,
+		Inbox:        actor.Inbox,
+		SharedInbox:  actor.Endpoints.SharedInbox,
+		Handle:       actor.PreferredUsername,
+		PublicKeyPem: actor.PublicKey.PublicKeyPem,
+	}, nil
+}
+
+func generateUserKeys() (*UserKeys, error) {
+	priv, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, err
+	}
+
+	privBytes, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		return nil, err
+	}
+	privPem := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: privBytes})
+
+	pubBytes, err := x509.MarshalPKIXPublicKey(&priv.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	pubPem := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubBytes})
+
+	return &UserKeys{
+		PublicKey:  string(pubPem),
+		PrivateKey: string(privPem),
+	}, nil
+}
+
+func parsePrivateKey(pemStr string) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("invalid PEM private key")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("private key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+func parsePublicKey(pemStr string) (*rsa.PublicKey, error) {
+	block, _ := pem.Decode([]byte(pemStr))
+	if block == nil {
+		return nil, errors.New("invalid PEM public key")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	rsaKey, ok := key.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("public key is not RSA")
+	}
+	return rsaKey, nil
+}
+
+// signRequest signs req with draft-cavage HTTP Signatures, covering
+// (request-target), host, date and digest, as most ActivityPub
+// implementations expect.
+func signRequest(req *http.Request, body []byte, keyId string, priv *rsa.PrivateKey) error {
+	digest := sha256.Sum256(body)
+	req.Header.Set("Digest", "SHA-256="+base64.StdEncoding.EncodeToString(digest[:]))
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+	req.Header.Set("Host", req.URL.Host)
+
+	signedHeaders := []string{"(request-target)", "host", "date", "digest"}
+	signingString := buildSigningString(req, signedHeaders)
+
+	hashed := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+	if err != nil {
+		return err
+	}
+
+	header := fmt.Sprintf(
+		`keyId="%s",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		keyId, strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(sig))
+	req.Header.Set("Signature", header)
+	return nil
+}
+
+func buildSigningString(req *http.Request, headers []string) string {
+	lines := make([]string, 0, len(headers))
+	for _, h := range headers {
+		if h == "(request-target)" {
+			lines = append(lines, fmt.Sprintf("(request-target): %s %s",
+				strings.ToLower(req.Method), req.URL.RequestURI()))
+			continue
+		}
+		lines = append(lines, fmt.Sprintf("%s: %s", h, req.Header.Get(h)))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// requiredSignedHeaders must all appear in a Signature header's "headers"
+// list, otherwise a sender could sign an innocuous header like Date and
+// leave the method, path and body completely unauthenticated.
+var requiredSignedHeaders = []string{"(request-target)", "host", "digest"}
+
+// signatureDateSkew is how far a signed request's Date header may drift
+// from wall clock time before it's rejected as stale or replayed.
+const signatureDateSkew = 5 * time.Minute
+
+// verifyHTTPSignature checks the Signature header on an inbound request
+// against the sender's fetched public key.
+func verifyHTTPSignature(r *http.Request, body []byte, publicKeyPem string) error {
+	sigHeader := r.Header.Get("Signature")
+	if sigHeader == "" {
+		return errors.New("missing Signature header")
+	}
+
+	params := parseSignatureHeader(sigHeader)
+	headerList := strings.Fields(params["headers"])
+	if len(headerList) == 0 {
+		return errors.New("empty signed header list")
+	}
+	for _, required := range requiredSignedHeaders {
+		if !headerListContains(headerList, required) {
+			return fmt.Errorf("signature does not cover required header %q", required)
+		}
+	}
+
+	if err := checkDateFreshness(r.Header.Get("Date")); err != nil {
+		return err
+	}
+
+	digest := sha256.Sum256(body)
+	expected := "SHA-256=" + base64.StdEncoding.EncodeToString(digest[:])
+	if r.Header.Get("Digest") != expected {
+		return errors.New("digest mismatch")
+	}
+
+	// net/http moves an inbound request's Host header into r.Host, so put
+	// it back for the "host" line of the signing string.
+	header := r.Header
+	if _, ok := header["Host"]; !ok && r.Host != "" {
+		header = r.Header.Clone()
+		header.Set("Host", r.Host)
+	}
+	fakeReq := &http.Request{Method: r.Method, URL: r.URL, Header: header}
+	signingString := buildSigningString(fakeReq, headerList)
+
+	sig, err := base64.StdEncoding.DecodeString(params["signature"])
+	if err != nil {
+		return err
+	}
+
+	pub, err := parsePublicKey(publicKeyPem)
+	if err != nil {
+		return err
+	}
+
+	hashed := sha256.Sum256([]byte(signingString))
+	return rsa.VerifyPKCS1v15(pub, crypto.SHA256, hashed[:], sig)
+}
+
+func headerListContains(headers []string, name string) bool {
+	for _, h := range headers {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDateFreshness rejects requests whose Date header is missing,
+// unparseable, or too far from the current time to guard against replay
+// of an old, otherwise-valid signed request.
+func checkDateFreshness(dateHeader string) error {
+	if dateHeader == "" {
+		return errors.New("missing Date header")
+	}
+	t, err := http.ParseTime(dateHeader)
+	if err != nil {
+		return fmt.Errorf("invalid Date header: %w", err)
+	}
+	if skew := time.Since(t); skew > signatureDateSkew || skew < -signatureDateSkew {
+		return errors.New("Date header outside acceptable skew")
+	}
+	return nil
+}
+
+func parseSignatureHeader(header string) map[string]string {
+	params := make(map[string]string)
+	for _, part := range strings.Split(header, ",") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[kv[0]] = strings.Trim(kv[1], `"`)
+	}
+	return params
+}
+
+// deliveryJob is one signed POST to a remote inbox, retried by the
+// federation worker until it succeeds or is abandoned.
+type deliveryJob struct {
+	inbox    string
+	body     []byte
+	keyId    string
+	priv     *rsa.PrivateKey
+	attempts int
+}
+
+var deliveryQueue = make(chan deliveryJob, 256)
+
+// enqueueDelivery signs activity as u and hands it to the federation
+// worker for delivery to singleInbox. federatePost calls it once for each
+// of a user's follower inboxes, already deduped by shared inbox.
+func enqueueDelivery(s *site, u *User, singleInbox string, activity interface{}) {
+	keys, err := s.persistence.GetUserKeys(u.Id)
+	if err != nil {
+		log.Println("error loading keys for delivery", u.Username, err)
+		return
+	}
+	priv, err := parsePrivateKey(keys.PrivateKey)
+	if err != nil {
+		log.Println("error parsing private key for delivery", u.Username, err)
+		return
+	}
+
+	body, err := json.Marshal(activity)
+	if err != nil {
+		log.Println("error marshaling activity", err)
+		return
+	}
+
+	keyId := actorId(s.baseUrl, u.Username) + "#main-key"
+	deliveryQueue <- deliveryJob{inbox: singleInbox, body: body, keyId: keyId, priv: priv}
+}
+
+func federatePost(s *site, u *User, p *Post) {
+	inboxes, err := s.persistence.FollowerInboxes(u.Id)
+	if err != nil {
+		log.Println("error loading followers for", u.Username, err)
+		return
+	}
+	activity := createActivityForPost(s.baseUrl, u, p)
+	for _, inbox := range inboxes {
+		enqueueDelivery(s, u, inbox, activity)
+	}
+}
+
+const maxDeliveryAttempts = 5
+
+// startFederationWorker drains deliveryQueue, signing and POSTing each job
+// and re-enqueueing failed deliveries with a short backoff.
+func startFederationWorker() {
+	go func() {
+		for job := range deliveryQueue {
+			if err := deliverOnce(job); err != nil {
+				job.attempts++
+				if job.attempts >= maxDeliveryAttempts {
+					log.Println("giving up delivering to", job.inbox, "after", job.attempts, "attempts:", err)
+					continue
+				}
+				log.Println("delivery to", job.inbox, "failed, will retry:", err)
+				go func(j deliveryJob) {
+					time.Sleep(time.Duration(j.attempts) * 30 * time.Second)
+					deliveryQueue <- j
+				}(job)
+			}
+		}
+	}()
+}
+
+func deliverOnce(job deliveryJob) error {
+	req, err := http.NewRequest("POST", job.inbox, bytes.NewReader(job.body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", activityJSONType)
+
+	if err := signRequest(req, job.body, job.keyId, job.priv); err != nil {
+		return err
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("remote inbox %s returned %s", job.inbox, resp.Status)
+	}
+	return nil
+}
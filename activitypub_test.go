@@ -0,0 +1,137 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newInboxRequest(t *testing.T, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest("POST", "https://example.com/u/alice/inbox", bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("NewRequest: %v", err)
+	}
+	req.URL = &url.URL{Path: "/u/alice/inbox"}
+	req.Host = "example.com"
+	return req
+}
+
+func TestSignRequestVerifyHTTPSignatureRoundTrip(t *testing.T) {
+	keys, err := generateUserKeys()
+	if err != nil {
+		t.Fatalf("generateUserKeys: %v", err)
+	}
+	priv, err := parsePrivateKey(keys.PrivateKey)
+	if err != nil {
+		t.Fatalf("parsePrivateKey: %v", err)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+	req := newInboxRequest(t, body)
+
+	if err := signRequest(req, body, "https://origin.example/u/bob#main-key", priv); err != nil {
+		t.Fatalf("signRequest: %v", err)
+	}
+
+	if err := verifyHTTPSignature(req, body, keys.PublicKey); err != nil {
+		t.Fatalf("verifyHTTPSignature on a validly signed request: %v", err)
+	}
+
+	if err := verifyHTTPSignature(req, []byte("tampered body"), keys.PublicKey); err == nil {
+		t.Fatal("expected verifyHTTPSignature to reject a body that doesn't match the Digest")
+	}
+}
+
+func TestVerifyHTTPSignatureRejectsPartialHeaderCoverage(t *testing.T) {
+	keys, err := generateUserKeys()
+	if err != nil {
+		t.Fatalf("generateUserKeys: %v", err)
+	}
+	priv, err := parsePrivateKey(keys.PrivateKey)
+	if err != nil {
+		t.Fatalf("parsePrivateKey: %v", err)
+	}
+
+	body := []byte(`{"type":"Follow"}`)
+	req := newInboxRequest(t, body)
+	req.Header.Set("Date", time.Now().UTC().Format(http.TimeFormat))
+
+	// Sign only "date", leaving the method/path/body unauthenticated.
+	signedHeaders := []string{"date"}
+	signingString := buildSigningString(req, signedHeaders)
+	hashed := sha256.Sum256([]byte(signingString))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, priv, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatalf("SignPKCS1v15: %v", err)
+	}
+	req.Header.Set("Signature", fmt.Sprintf(
+		`keyId="k",algorithm="rsa-sha256",headers="%s",signature="%s"`,
+		strings.Join(signedHeaders, " "), base64.StdEncoding.EncodeToString(sig)))
+
+	if err := verifyHTTPSignature(req, body, keys.PublicKey); err == nil {
+		t.Fatal("expected verifyHTTPSignature to reject a signature that doesn't cover (request-target)/host/digest")
+	}
+}
+
+func TestCheckDateFreshnessRejectsStaleDate(t *testing.T) {
+	stale := time.Now().Add(-time.Hour).UTC().Format(http.TimeFormat)
+	if err := checkDateFreshness(stale); err == nil {
+		t.Fatal("expected an hour-old Date header to be rejected")
+	}
+
+	fresh := time.Now().UTC().Format(http.TimeFormat)
+	if err := checkDateFreshness(fresh); err != nil {
+		t.Fatalf("expected a current Date header to pass, got %v", err)
+	}
+}
+
+// TestDeleteRemotePostMatchesObjectIdNotActorId guards against
+// DeleteRemotePost matching on the wrong column: a Delete{Note}'s
+// object id is almost never equal to its author's actor id, so
+// matching on actor_id would either no-op or (worse) wipe out every
+// other post from that actor.
+func TestDeleteRemotePostMatchesObjectIdNotActorId(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	actor := "https://remote.example/u/bob"
+	keptId := "https://remote.example/post/1"
+	deletedId := "https://remote.example/post/2"
+
+	if _, err := p.AddRemotePost(actor, keptId, "kept"); err != nil {
+		t.Fatalf("AddRemotePost(kept): %v", err)
+	}
+	if _, err := p.AddRemotePost(actor, deletedId, "deleted"); err != nil {
+		t.Fatalf("AddRemotePost(deleted): %v", err)
+	}
+
+	if err := p.DeleteRemotePost(deletedId); err != nil {
+		t.Fatalf("DeleteRemotePost: %v", err)
+	}
+
+	var remaining int
+	if err := p.Database.QueryRow(`select count(*) from remote_post where actor_id = ?`, actor).Scan(&remaining); err != nil {
+		t.Fatalf("counting remaining posts: %v", err)
+	}
+	if remaining != 1 {
+		t.Fatalf("expected exactly one of the actor's posts to survive the delete, got %d", remaining)
+	}
+
+	var deletedCount int
+	if err := p.Database.QueryRow(`select count(*) from remote_post where object_id = ?`, deletedId).Scan(&deletedCount); err != nil {
+		t.Fatalf("counting deleted post: %v", err)
+	}
+	if deletedCount != 0 {
+		t.Fatal("expected the post matching the deleted object id to be gone")
+	}
+}
@@ -0,0 +1,362 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ApiToken is a bearer token issued to a user for use with the /api/v1/
+// endpoints. Only the SHA-256 hash of the token is ever stored.
+type ApiToken struct {
+	Id       int
+	UserId   int
+	Label    string
+	Created  int
+	LastUsed int
+	Revoked  bool
+}
+
+// TokenPersistence is implemented by backends that have the api_tokens
+// table.
+type TokenPersistence interface {
+	CreateApiToken(userId int, label string) (token string, t *ApiToken, err error)
+	UserFromToken(token string) (*User, error)
+	TouchApiToken(tokenHash string)
+	UserApiTokens(userId int) ([]*ApiToken, error)
+	RevokeApiToken(userId, tokenId int) error
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newApiToken() (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(buf), nil
+}
+
+// apiUser and apiPost mirror User/Post for JSON responses without
+// leaking the password hash or other internal fields.
+type apiUser struct {
+	Id       int    `json:"id"`
+	Username string `json:"username"`
+}
+
+type apiPost struct {
+	Id     int     `json:"id"`
+	UUID   string  `json:"uuid"`
+	Body   string  `json:"body"`
+	Posted int     `json:"posted"`
+	User   apiUser `json:"user"`
+}
+
+func toApiUser(u *User) apiUser {
+	return apiUser{Id: u.Id, Username: u.Username}
+}
+
+func toApiPost(p *Post) apiPost {
+	return apiPost{Id: p.Id, UUID: p.UUID, Body: p.Body, Posted: p.Posted, User: toApiUser(p.User)}
+}
+
+func apiError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": message})
+}
+
+// withApiAuth authenticates the Authorization: Bearer <token> header
+// against TokenPersistence and passes the resulting user to fn.
+func withApiAuth(s *site, fn func(w http.ResponseWriter, r *http.Request, u *User)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		tp, ok := s.persistence.(TokenPersistence)
+		if !ok {
+			apiError(w, http.StatusNotImplemented, "token auth not available")
+			return
+		}
+
+		auth := r.Header.Get("Authorization")
+		if !strings.HasPrefix(auth, "Bearer ") {
+			apiError(w, http.StatusUnauthorized, "missing bearer token")
+			return
+		}
+		token := strings.TrimPrefix(auth, "Bearer ")
+
+		u, err := tp.UserFromToken(token)
+		if err != nil {
+			apiError(w, http.StatusUnauthorized, "invalid token")
+			return
+		}
+		if u.Banned {
+			apiError(w, http.StatusForbidden, "account banned")
+			return
+		}
+		tp.TouchApiToken(hashToken(token))
+
+		fn(w, r, u)
+	}
+}
+
+// apiTokensHandler exchanges a username/password for a bearer token.
+func apiTokensHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	tp, ok := s.persistence.(TokenPersistence)
+	if !ok {
+		apiError(w, http.StatusNotImplemented, "token auth not available")
+		return
+	}
+
+	var req struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, "bad request body")
+		return
+	}
+
+	u, ok := s.persistence.GetUser(r.Context(), req.Username)
+	if !ok || !u.CheckPassword(req.Password) {
+		apiError(w, http.StatusUnauthorized, "invalid credentials")
+		return
+	}
+
+	token, _, err := tp.CreateApiToken(u.Id, "api")
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "could not create token")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"token": token})
+}
+
+func parseLimitOffset(r *http.Request, defaultLimit int) (int, int) {
+	limit := defaultLimit
+	offset := 0
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			limit = n
+		}
+	}
+	if v := r.URL.Query().Get("offset"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil {
+			offset = n
+		}
+	}
+	return limit, offset
+}
+
+func apiPostsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	switch r.Method {
+	case http.MethodGet:
+		limit, offset := parseLimitOffset(r, s.itemsPerPage)
+		posts, err := s.persistence.GetAllPosts(r.Context(), limit, offset)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, "could not load posts")
+			return
+		}
+		out := make([]apiPost, 0, len(posts))
+		for _, p := range posts {
+			out = append(out, toApiPost(p))
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(out)
+	case http.MethodPost:
+		withApiAuth(s, apiCreatePost)(w, r)
+	default:
+		apiError(w, http.StatusMethodNotAllowed, "GET or POST required")
+	}
+}
+
+func apiCreatePost(w http.ResponseWriter, r *http.Request, u *User) {
+	s := apiSiteFromContext(r.Context())
+	var req struct {
+		Body     string   `json:"body"`
+		Channels []string `json:"channels"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		apiError(w, http.StatusBadRequest, "bad request body")
+		return
+	}
+
+	channels := make([]*Channel, 0, len(req.Channels))
+	for _, slug := range req.Channels {
+		if c, err := s.persistence.GetChannel(r.Context(), *u, slug); err == nil {
+			channels = append(channels, c)
+		}
+	}
+
+	post, err := s.persistence.AddPost(r.Context(), *u, req.Body, channels)
+	if err == ErrRateLimited {
+		apiError(w, http.StatusTooManyRequests, "rate limit exceeded")
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "could not create post")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(toApiPost(post))
+}
+
+func apiPostByIdHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	id, err := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/api/v1/posts/"))
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "bad post id")
+		return
+	}
+	post, err := s.persistence.GetPost(r.Context(), id)
+	if err != nil {
+		apiError(w, http.StatusNotFound, "post not found")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(toApiPost(post))
+}
+
+func apiUserPostsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	username := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/posts")
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		apiError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	limit, offset := parseLimitOffset(r, s.itemsPerPage)
+	posts, err := s.persistence.GetAllUserPosts(r.Context(), u, limit, offset)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "could not load posts")
+		return
+	}
+	out := make([]apiPost, 0, len(posts))
+	for _, p := range posts {
+		out = append(out, toApiPost(p))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(out)
+}
+
+func apiUserChannelsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	username := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/api/v1/users/"), "/channels")
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok {
+		apiError(w, http.StatusNotFound, "user not found")
+		return
+	}
+	channels, err := s.persistence.UserChannels(r.Context(), *u)
+	if err != nil {
+		apiError(w, http.StatusInternalServerError, "could not load channels")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(channels)
+}
+
+func apiChannelsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	if r.Method != http.MethodPost {
+		apiError(w, http.StatusMethodNotAllowed, "POST required")
+		return
+	}
+	withApiAuth(s, func(w http.ResponseWriter, r *http.Request, u *User) {
+		var req struct {
+			Names []string `json:"names"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			apiError(w, http.StatusBadRequest, "bad request body")
+			return
+		}
+		channels, err := s.persistence.AddChannels(r.Context(), *u, req.Names)
+		if err != nil {
+			apiError(w, http.StatusInternalServerError, "could not create channels")
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(channels)
+	})(w, r)
+}
+
+type apiSiteKey struct{}
+
+func apiSiteFromContext(ctx context.Context) *site {
+	s, _ := ctx.Value(apiSiteKey{}).(*site)
+	return s
+}
+
+// registerApiRoutes mounts the /api/v1/ JSON API on the default mux.
+func registerApiRoutes(s *site) {
+	withSite := func(fn func(http.ResponseWriter, *http.Request, *site)) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			ctx := context.WithValue(r.Context(), apiSiteKey{}, s)
+			fn(w, r.WithContext(ctx), s)
+		}
+	}
+
+	http.HandleFunc("/api/v1/tokens", withSite(apiTokensHandler))
+	http.HandleFunc("/api/v1/posts", withSite(apiPostsHandler))
+	http.HandleFunc("/api/v1/posts/", withSite(apiPostByIdHandler))
+	http.HandleFunc("/api/v1/channels", withSite(apiChannelsHandler))
+	http.HandleFunc("/settings/tokens/", withSite(tokenSettingsHandler))
+	http.HandleFunc("/api/v1/users/", withSite(func(w http.ResponseWriter, r *http.Request, s *site) {
+		switch {
+		case strings.HasSuffix(r.URL.Path, "/posts"):
+			apiUserPostsHandler(w, r, s)
+		case strings.HasSuffix(r.URL.Path, "/channels"):
+			apiUserChannelsHandler(w, r, s)
+		default:
+			apiError(w, http.StatusNotFound, "not found")
+		}
+	}))
+}
+
+// tokenSettingsHandler lets a logged-in user issue and revoke their own
+// API tokens from the HTML UI.
+func tokenSettingsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	u := getCurrentUser(r, s)
+	if u == nil {
+		http.Redirect(w, r, "/login/", http.StatusFound)
+		return
+	}
+	tp, ok := s.persistence.(TokenPersistence)
+	if !ok {
+		http.Error(w, "not available", http.StatusNotImplemented)
+		return
+	}
+
+	if r.Method == http.MethodPost {
+		switch r.FormValue("action") {
+		case "create":
+			if _, _, err := tp.CreateApiToken(u.Id, r.FormValue("label")); err != nil {
+				http.Error(w, "could not create token", http.StatusInternalServerError)
+				return
+			}
+		case "revoke":
+			if id, err := strconv.Atoi(r.FormValue("token_id")); err == nil {
+				tp.RevokeApiToken(u.Id, id)
+			}
+		}
+		http.Redirect(w, r, "/settings/tokens/", http.StatusFound)
+		return
+	}
+
+	tokens, err := tp.UserApiTokens(u.Id)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, "token_settings", map[string]interface{}{
+		"Tokens": tokens,
+	})
+}
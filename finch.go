@@ -18,6 +18,11 @@ func makeHandler(fn func(http.ResponseWriter, *http.Request, *site), s *site) ht
 }
 
 func main() {
+	if os.Getenv("FINCH_S3_LOCAL") == "1" {
+		srv := startFakeS3Server()
+		os.Setenv("FINCH_S3_ENDPOINT", srv.Listener.Addr().String())
+	}
+
 	p := newPersistence(os.Getenv("FINCH_DB_FILE"))
 	defer p.Close()
 	templateDir = os.Getenv("FINCH_TEMPLATE_DIR")
@@ -26,12 +31,30 @@ func main() {
 		os.Getenv("FINCH_BASE_URL"),
 		sessions.NewCookieStore([]byte(os.Getenv("FINCH_SECRET"))),
 		os.Getenv("FINCH_ITEMS_PER_PAGE"))
+	s.mediaStore = newMediaStore()
+
+	// federate every new post to followers on other servers, and queue
+	// any URLs it contains for Wayback Machine archival
+	s.persistence.SetOnPostCreated(func(u User, post *Post) {
+		federatePost(s, &u, post)
+		enqueueArchival(s.persistence, post)
+	})
+	startFederationWorker()
+	startWaybackWorker(s.persistence)
 
 	http.HandleFunc("/", makeHandler(indexHandler, s))
 	http.HandleFunc("/post/", makeHandler(postHandler, s))
 	http.HandleFunc("/search/", makeHandler(searchHandler, s))
+	http.HandleFunc("/archive/", makeHandler(archiveIndexHandler, s))
+	http.HandleFunc("/upload/", makeHandler(uploadHandler, s))
+	registerApiRoutes(s)
 
-	http.HandleFunc("/u/", makeHandler(userDispatch, s))
+	http.HandleFunc("/admin/users/", makeHandler(adminUsersHandler, s))
+	http.HandleFunc("/admin/posts/", makeHandler(adminPostsHandler, s))
+	http.HandleFunc("/admin/log/", makeHandler(adminLogHandler, s))
+
+	http.HandleFunc("/.well-known/webfinger", makeHandler(webfingerHandler, s))
+	http.HandleFunc("/u/", makeHandler(userOrActorDispatch, s))
 
 	// authy stuff
 	http.HandleFunc("/register/", makeHandler(registerHandler, s))
@@ -40,8 +63,10 @@ func main() {
 
 	// static misc.
 	http.HandleFunc("/favicon.ico", faviconHandler)
-	http.Handle("/media/", http.StripPrefix("/media/",
-		http.FileServer(http.Dir(os.Getenv("FINCH_MEDIA_DIR")))))
+	if os.Getenv("FINCH_MEDIA_BACKEND") != "s3" {
+		http.Handle("/media/", http.StripPrefix("/media/",
+			http.FileServer(http.Dir(os.Getenv("FINCH_MEDIA_DIR")))))
+	}
 	log.Println("running on " + os.Getenv("FINCH_PORT"))
 	log.Fatal(http.ListenAndServe(":"+os.Getenv("FINCH_PORT"), nil))
 }
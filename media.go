@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+	"github.com/nu7hatch/gouuid"
+)
+
+// sessionName is the cookie name used for the logged-in-user session,
+// shared with the existing login/logout handlers.
+const sessionName = "finch-session"
+
+// MediaStore abstracts storage for uploaded attachments so Finch can run
+// against a local directory or an S3-compatible object store.
+type MediaStore interface {
+	Put(ctx context.Context, key, contentType string, r io.Reader) (url string, err error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// newMediaStore picks a MediaStore based on FINCH_MEDIA_BACKEND ("local",
+// the default, or "s3").
+func newMediaStore() MediaStore {
+	switch os.Getenv("FINCH_MEDIA_BACKEND") {
+	case "s3":
+		return newS3MediaStore()
+	default:
+		return &localMediaStore{dir: os.Getenv("FINCH_MEDIA_DIR")}
+	}
+}
+
+type localMediaStore struct {
+	dir string
+}
+
+func (l *localMediaStore) Put(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	f, err := os.Create(path.Join(l.dir, key))
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := io.Copy(f, r); err != nil {
+		return "", err
+	}
+	return "/media/" + key, nil
+}
+
+func (l *localMediaStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(path.Join(l.dir, key))
+}
+
+func (l *localMediaStore) Delete(ctx context.Context, key string) error {
+	return os.Remove(path.Join(l.dir, key))
+}
+
+type s3MediaStore struct {
+	client    *minio.Client
+	bucket    string
+	publicURL string
+}
+
+func newS3MediaStore() *s3MediaStore {
+	endpoint := os.Getenv("FINCH_S3_ENDPOINT")
+	secure := os.Getenv("FINCH_S3_LOCAL") != "1"
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewStaticV4(
+			os.Getenv("FINCH_S3_ACCESS_KEY"),
+			os.Getenv("FINCH_S3_SECRET_KEY"),
+			""),
+		Secure: secure,
+		Region: os.Getenv("FINCH_S3_REGION"),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	return &s3MediaStore{
+		client:    client,
+		bucket:    os.Getenv("FINCH_S3_BUCKET"),
+		publicURL: strings.TrimRight(os.Getenv("FINCH_S3_PUBLIC_URL"), "/"),
+	}
+}
+
+func (s *s3MediaStore) Put(ctx context.Context, key, contentType string, r io.Reader) (string, error) {
+	_, err := s.client.PutObject(ctx, s.bucket, key, r, -1,
+		minio.PutObjectOptions{ContentType: contentType})
+	if err != nil {
+		return "", err
+	}
+	return s.publicURL + "/" + key, nil
+}
+
+func (s *s3MediaStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.client.GetObject(ctx, s.bucket, key, minio.GetObjectOptions{})
+}
+
+func (s *s3MediaStore) Delete(ctx context.Context, key string) error {
+	return s.client.RemoveObject(ctx, s.bucket, key, minio.RemoveObjectOptions{})
+}
+
+// Attachment associates an uploaded file with the post it was submitted
+// with.
+type Attachment struct {
+	Id          int
+	PostId      int
+	Key         string
+	ContentType string
+	Size        int64
+}
+
+// PendingAttachment is how the post form refers to a file that's already
+// been uploaded via uploadHandler but has no post to attach to yet -- a
+// post doesn't get an id until AddPost returns, so the attachments table
+// row is only created once postHandler has one to give it.
+type PendingAttachment struct {
+	Key         string `json:"key"`
+	URL         string `json:"url"`
+	ContentType string `json:"content_type"`
+	Size        int64  `json:"size"`
+}
+
+// AttachingPersistence is implemented by backends that have the
+// attachments table.
+type AttachingPersistence interface {
+	AddAttachment(postId int, key, contentType string, size int64) (*Attachment, error)
+	GetAttachmentsForPost(postId int) ([]*Attachment, error)
+}
+
+const maxUploadSize = 20 << 20 // 20MB
+
+// uploadHandler accepts a multipart upload and stores it in the
+// configured MediaStore under a fresh UUID key. A post doesn't have an
+// id yet at upload time, so the response carries everything postHandler
+// needs (key, content type, size) to associate the file with the post
+// once AddPost returns one; see PendingAttachment. Auth follows the same
+// session check as postHandler.
+func uploadHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	u := getCurrentUser(r, s)
+	if u == nil {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		http.Error(w, "bad upload", http.StatusBadRequest)
+		return
+	}
+
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		http.Error(w, "missing file", http.StatusBadRequest)
+		return
+	}
+	defer file.Close()
+
+	key, err := newAttachmentKey(header)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := header.Header.Get("Content-Type")
+	url, err := s.mediaStore.Put(r.Context(), key, contentType, file)
+	if err != nil {
+		log.Println("error storing upload", err)
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	writeJSON(w, PendingAttachment{Key: key, URL: url, ContentType: contentType, Size: header.Size})
+}
+
+func newAttachmentKey(header *multipart.FileHeader) (string, error) {
+	u4, err := uuid.NewV4()
+	if err != nil {
+		return "", err
+	}
+	ext := path.Ext(header.Filename)
+	return u4.String() + ext, nil
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Println("error encoding json response", err)
+	}
+}
+
+// getCurrentUser looks up the logged-in user from the session cookie,
+// the same way postHandler does for the HTML post form. A banned user's
+// session is treated as logged out.
+func getCurrentUser(r *http.Request, s *site) *User {
+	session, err := s.sessionStore.Get(r, sessionName)
+	if err != nil {
+		return nil
+	}
+	username, ok := session.Values["username"].(string)
+	if !ok || username == "" {
+		return nil
+	}
+	u, ok := s.persistence.GetUser(r.Context(), username)
+	if !ok || u.Banned {
+		return nil
+	}
+	return u
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// startFakeS3Server runs a minimal in-process S3-compatible server so
+// FINCH_S3_ENDPOINT can point at something during local development
+// without standing up real MinIO. It only backs FINCH_S3_LOCAL=1 and
+// should never be used in production. It stores single-part PUTs and
+// serves every other request from disk, so a DELETE leaves the object in
+// place; multipart upload is not supported.
+func startFakeS3Server() *httptest.Server {
+	dir := os.Getenv("FINCH_MEDIA_DIR")
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		fakeS3Object(w, r, dir)
+	})
+
+	srv := httptest.NewUnstartedServer(mux)
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.Listener.Close()
+	srv.Listener = l
+	srv.Start()
+
+	log.Println("fake S3 dev server listening on", srv.URL)
+	return srv
+}
+
+// fakeS3Object handles a PUT by writing the request body to dir under
+// the requested bucket/key path; everything else (GET, HEAD, DELETE)
+// falls back to serving that same directory as static files.
+func fakeS3Object(w http.ResponseWriter, r *http.Request, dir string) {
+	if r.Method != http.MethodPut {
+		http.FileServer(http.Dir(dir)).ServeHTTP(w, r)
+		return
+	}
+
+	target := filepath.Join(dir, filepath.FromSlash(path.Clean("/"+r.URL.Path)))
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	f, err := os.Create(target)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+
+	if _, err := io.Copy(f, r.Body); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("ETag", `"fake"`)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Permission names understood by HasPermission and the default role
+// seeding below.
+const (
+	PermCreatePost     = "CreatePost"
+	PermDeleteAnyPost  = "DeleteAnyPost"
+	PermBanUser        = "BanUser"
+	PermManageChannels = "ManageChannels"
+	PermViewAdminLog   = "ViewAdminLog"
+	PermManageUsers    = "ManageUsers"
+)
+
+// defaultRoles seeds the permission set classic forum software ships
+// with: a user can post, a moderator can also moderate content and
+// users, and an admin can do everything.
+var defaultRoles = []struct {
+	Name        string
+	Permissions []string
+}{
+	{"user", []string{PermCreatePost}},
+	{"moderator", []string{
+		PermCreatePost, PermDeleteAnyPost, PermBanUser, PermManageChannels,
+	}},
+	{"admin", []string{
+		PermCreatePost, PermDeleteAnyPost, PermBanUser, PermManageChannels,
+		PermViewAdminLog, PermManageUsers,
+	}},
+}
+
+// AdminLogEntry records one privileged action for the /admin/log/ page.
+type AdminLogEntry struct {
+	Id         int
+	ActorId    int
+	Action     string
+	TargetType string
+	TargetId   int
+	Details    string
+	IP         string
+	Ts         int
+}
+
+// ModerationPersistence is implemented by backends that have the
+// roles/user_roles/admin_log tables.
+type ModerationPersistence interface {
+	HasPermission(userId int, perm string) bool
+	LogAdminAction(actorId int, action, targetType string, targetId int, details map[string]interface{}, ip string) error
+	AdminLog(limit, offset int) ([]*AdminLogEntry, error)
+	AssignRole(userId int, roleName string) error
+	DeletePost(postId int) error
+	SetUserBanned(userId int, banned bool) error
+}
+
+func marshalDetails(details map[string]interface{}) string {
+	if details == nil {
+		return "{}"
+	}
+	b, err := json.Marshal(details)
+	if err != nil {
+		return "{}"
+	}
+	return string(b)
+}
+
+// requirePermission is a thin guard other handlers wrap around: it
+// reports whether the logged-in user holds perm, and callers respond
+// with a 403 when it doesn't.
+func requirePermission(s *site, r *http.Request, perm string) (*User, bool) {
+	u := getCurrentUser(r, s)
+	if u == nil {
+		return nil, false
+	}
+	mp, ok := s.persistence.(ModerationPersistence)
+	if !ok {
+		return nil, false
+	}
+	return u, mp.HasPermission(u.Id, perm)
+}
+
+func adminUsersHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	perm := PermManageUsers
+	if a := r.FormValue("action"); r.Method == http.MethodPost && (a == "ban" || a == "unban") {
+		perm = PermBanUser
+	}
+	actor, ok := requirePermission(s, r, perm)
+	if !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	mp := s.persistence.(ModerationPersistence)
+
+	if r.Method == http.MethodPost {
+		userId := atoiOrZero(r.FormValue("user_id"))
+		switch r.FormValue("action") {
+		case "ban", "unban":
+			banned := r.FormValue("action") == "ban"
+			if err := mp.SetUserBanned(userId, banned); err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			mp.LogAdminAction(actor.Id, r.FormValue("action")+"_user", "user", userId, nil, r.RemoteAddr)
+			http.Redirect(w, r, "/admin/users/", http.StatusFound)
+			return
+		case "set_role":
+			role := r.FormValue("role")
+			if err := mp.AssignRole(userId, role); err != nil {
+				http.Error(w, "internal error", http.StatusInternalServerError)
+				return
+			}
+			mp.LogAdminAction(actor.Id, "set_role", "user", userId,
+				map[string]interface{}{"role": role}, r.RemoteAddr)
+			http.Redirect(w, r, "/admin/users/", http.StatusFound)
+			return
+		}
+	}
+	renderTemplate(w, "admin_users", map[string]interface{}{})
+}
+
+func adminPostsHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	actor, ok := requirePermission(s, r, PermDeleteAnyPost)
+	if !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	mp := s.persistence.(ModerationPersistence)
+
+	if r.Method == http.MethodPost && r.FormValue("action") == "delete" {
+		postId := atoiOrZero(r.FormValue("post_id"))
+		if err := mp.DeletePost(postId); err != nil {
+			http.Error(w, "internal error", http.StatusInternalServerError)
+			return
+		}
+		mp.LogAdminAction(actor.Id, "delete_post", "post", postId, nil, r.RemoteAddr)
+		http.Redirect(w, r, "/admin/posts/", http.StatusFound)
+		return
+	}
+	renderTemplate(w, "admin_posts", map[string]interface{}{})
+}
+
+func adminLogHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	if _, ok := requirePermission(s, r, PermViewAdminLog); !ok {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	mp := s.persistence.(ModerationPersistence)
+
+	page := 0
+	if v := r.URL.Query().Get("page"); v != "" {
+		page = atoiOrZero(v)
+	}
+	entries, err := mp.AdminLog(s.itemsPerPage, page*s.itemsPerPage)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, "admin_log", map[string]interface{}{
+		"Entries": entries,
+		"Page":    page,
+	})
+}
+
+func atoiOrZero(s string) int {
+	n := 0
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return 0
+		}
+		n = n*10 + int(c-'0')
+	}
+	return n
+}
+
+// ErrRateLimited is returned by AddPost when a user who has already
+// posted FINCH_POST_RATE_PER_MIN times in the last minute tries to post
+// again. postHandler
+// should turn it into an HTTP 429.
+var ErrRateLimited = errors.New("rate limit exceeded")
+
+// postRateLimiter tracks recent post timestamps per user in memory so
+// AddPost can reject floods without a DB round trip on every post.
+type postRateLimiter struct {
+	mu        sync.Mutex
+	perMinute int
+	history   map[int][]time.Time
+}
+
+func newPostRateLimiter(perMinute int) *postRateLimiter {
+	return &postRateLimiter{perMinute: perMinute, history: make(map[int][]time.Time)}
+}
+
+// Allow reports whether userId may post now under
+// FINCH_POST_RATE_PER_MIN and, if so, records the post; rejected
+// attempts are not counted.
+func (l *postRateLimiter) Allow(userId int) bool {
+	if l.perMinute <= 0 {
+		return true
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	cutoff := time.Now().Add(-time.Minute)
+	recent := l.history[userId][:0]
+	for _, t := range l.history[userId] {
+		if t.After(cutoff) {
+			recent = append(recent, t)
+		}
+	}
+	if len(recent) >= l.perMinute {
+		l.history[userId] = recent
+		return false
+	}
+	l.history[userId] = append(recent, time.Now())
+	return true
+}
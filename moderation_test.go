@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCreateUserGetsDefaultRoleAndCanPost(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if !p.HasPermission(u.Id, PermCreatePost) {
+		t.Fatal("expected newly created user to have CreatePost permission")
+	}
+	if p.HasPermission(u.Id, PermManageUsers) {
+		t.Fatal("expected newly created user not to have ManageUsers permission")
+	}
+
+	if err := p.AssignRole(u.Id, "admin"); err != nil {
+		t.Fatalf("AssignRole: %v", err)
+	}
+	if !p.HasPermission(u.Id, PermManageUsers) {
+		t.Fatal("expected user assigned the admin role to have ManageUsers permission")
+	}
+}
+
+// TestAssignRoleReplacesExistingRole guards against set_role piling up
+// duplicate user_roles rows: demoting an admin back to "user" must
+// actually drop the admin permissions, not just add a second role.
+func TestAssignRoleReplacesExistingRole(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	if err := p.AssignRole(u.Id, "admin"); err != nil {
+		t.Fatalf("AssignRole(admin): %v", err)
+	}
+	if !p.HasPermission(u.Id, PermManageUsers) {
+		t.Fatal("expected admin role to grant ManageUsers")
+	}
+
+	if err := p.AssignRole(u.Id, "user"); err != nil {
+		t.Fatalf("AssignRole(user): %v", err)
+	}
+	if p.HasPermission(u.Id, PermManageUsers) {
+		t.Fatal("expected demoting back to user to revoke ManageUsers")
+	}
+	if !p.HasPermission(u.Id, PermCreatePost) {
+		t.Fatal("expected the user role to still grant CreatePost")
+	}
+}
+
+// TestDeletePostRemovesDependentRows gives a post postchannel,
+// attachments and archived_urls rows, deletes it, and checks that the
+// delete succeeds and that the post and its attachments are gone.
+func TestDeletePostRemovesDependentRows(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	channels, err := p.AddChannels(context.Background(), *u, []string{"general"})
+	if err != nil || len(channels) != 1 {
+		t.Fatalf("AddChannels: %v", err)
+	}
+
+	post, err := p.AddPost(context.Background(), *u, "hello", channels)
+	if err != nil {
+		t.Fatalf("AddPost: %v", err)
+	}
+	if _, err := p.AddAttachment(post.Id, "key.png", "image/png", 123); err != nil {
+		t.Fatalf("AddAttachment: %v", err)
+	}
+	if err := p.AddArchivalRequest(post.Id, "https://example.com"); err != nil {
+		t.Fatalf("AddArchivalRequest: %v", err)
+	}
+
+	if err := p.DeletePost(post.Id); err != nil {
+		t.Fatalf("DeletePost: %v", err)
+	}
+	if _, err := p.GetPost(context.Background(), post.Id); err == nil {
+		t.Fatal("expected GetPost to fail after DeletePost")
+	}
+	attachments, err := p.GetAttachmentsForPost(post.Id)
+	if err != nil {
+		t.Fatalf("GetAttachmentsForPost: %v", err)
+	}
+	if len(attachments) != 0 {
+		t.Fatalf("expected attachments to be cleaned up, got %d", len(attachments))
+	}
+}
+
+// TestGetUserReportsBannedUser guards against SetUserBanned being a
+// cosmetic flag: GetUser must report the ban, since getCurrentUser and
+// withApiAuth refuse a user whose Banned flag is set.
+func TestGetUserReportsBannedUser(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+	if err := p.SetUserBanned(u.Id, true); err != nil {
+		t.Fatalf("SetUserBanned: %v", err)
+	}
+
+	got, ok := p.GetUser(context.Background(), "alice")
+	if !ok {
+		t.Fatal("expected GetUser to still find the banned user")
+	}
+	if !got.Banned {
+		t.Fatal("expected GetUser to report the user as banned")
+	}
+}
+
+func TestPostRateLimiterAllow(t *testing.T) {
+	l := newPostRateLimiter(2)
+
+	if !l.Allow(1) || !l.Allow(1) {
+		t.Fatal("expected first two posts within the limit to be allowed")
+	}
+	if l.Allow(1) {
+		t.Fatal("expected a third post within the same minute to be rejected")
+	}
+	if !l.Allow(2) {
+		t.Fatal("expected rate limiting to be tracked per user")
+	}
+}
+
+func TestPostRateLimiterUnlimitedWhenZero(t *testing.T) {
+	l := newPostRateLimiter(0)
+	for i := 0; i < 10; i++ {
+		if !l.Allow(1) {
+			t.Fatal("expected a non-positive perMinute to disable rate limiting")
+		}
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/nu7hatch/gouuid"
+)
+
+//go:embed migrations/postgres/*.sql
+var postgresMigrations embed.FS
+
+type PostgresPersistence struct {
+	Pool          *pgxpool.Pool
+	onPostCreated func(u User, p *Post)
+	rateLimiter   *postRateLimiter
+}
+
+func NewPostgresPersistence(dsn string) *PostgresPersistence {
+	pool, err := pgxpool.New(context.Background(), dsn)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &PostgresPersistence{Pool: pool}
+	if err := p.migrate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if err := p.seedDefaultRoles(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	perMinute, _ := strconv.Atoi(os.Getenv("FINCH_POST_RATE_PER_MIN"))
+	p.rateLimiter = newPostRateLimiter(perMinute)
+	return p
+}
+
+func (p *PostgresPersistence) migrate(ctx context.Context) error {
+	if _, err := p.Pool.Exec(ctx, `create table if not exists schema_migrations (
+        version text primary key,
+        applied_at bigint
+    )`); err != nil {
+		return err
+	}
+
+	files, err := postgresMigrations.ReadDir("migrations/postgres")
+	if err != nil {
+		return err
+	}
+	contents := make(map[string][]byte, len(files))
+	for _, f := range files {
+		b, err := postgresMigrations.ReadFile("migrations/postgres/" + f.Name())
+		if err != nil {
+			return err
+		}
+		contents[f.Name()] = b
+	}
+
+	return runMigrations(ctx, migrationRunner{
+		exec: func(ctx context.Context, query string) error {
+			_, err := p.Pool.Exec(ctx, query)
+			return err
+		},
+		applied: func(ctx context.Context, version string) (bool, error) {
+			var v string
+			err := p.Pool.QueryRow(ctx,
+				`select version from schema_migrations where version = $1`, version).Scan(&v)
+			if errors.Is(err, pgx.ErrNoRows) {
+				return false, nil
+			}
+			return err == nil, err
+		},
+		record: func(ctx context.Context, version string) error {
+			_, err := p.Pool.Exec(ctx,
+				`insert into schema_migrations(version, applied_at) values($1, $2)`,
+				version, time.Now().Unix())
+			return err
+		},
+	}, contents)
+}
+
+func (p *PostgresPersistence) Close() {
+	p.Pool.Close()
+}
+
+func (p *PostgresPersistence) SetOnPostCreated(fn func(u User, p *Post)) {
+	p.onPostCreated = fn
+}
+
+func (p *PostgresPersistence) GetUser(ctx context.Context, username string) (*User, bool) {
+	var id int
+	var password string
+	var banned bool
+	err := p.Pool.QueryRow(ctx,
+		`select id, password, banned from users where username = $1`, username).Scan(&id, &password, &banned)
+	if err != nil {
+		return nil, false
+	}
+	return &User{Id: id, Username: username, Password: []byte(password), Banned: banned}, true
+}
+
+func (p *PostgresPersistence) GetUserById(ctx context.Context, id int) (*User, error) {
+	var username, password string
+	var banned bool
+	err := p.Pool.QueryRow(ctx,
+		`select username, password, banned from users where id = $1`, id).Scan(&username, &password, &banned)
+	if err != nil {
+		return nil, err
+	}
+	return &User{Id: id, Username: username, Password: []byte(password), Banned: banned}, nil
+}
+
+func (p *PostgresPersistence) CreateUser(ctx context.Context, username, password string) (*User, error) {
+	var user User
+	user.Username = username
+	encpassword := user.SetPassword(password)
+
+	_, err := p.Pool.Exec(ctx, `insert into users(username, password) values($1, $2)`,
+		username, encpassword)
+	if err != nil {
+		return nil, err
+	}
+
+	u, _ := p.GetUser(ctx, username)
+	if u != nil {
+		if _, err := p.EnsureUserKeys(u.Id); err != nil {
+			log.Println("error generating activitypub keys for", username, err)
+		}
+		if err := p.AssignRole(u.Id, "user"); err != nil {
+			log.Println("error assigning default role to", username, err)
+		}
+	}
+	return u, nil
+}
+
+func (p *PostgresPersistence) UserChannels(ctx context.Context, u User) ([]*Channel, error) {
+	rows, err := p.Pool.Query(ctx, `select id, slug, label from channel where user_id = $1`, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make([]*Channel, 0)
+	for rows.Next() {
+		var id int
+		var slug, label string
+		rows.Scan(&id, &slug, &label)
+		channels = append(channels, &Channel{Id: id, Slug: slug, Label: label})
+	}
+	return channels, nil
+}
+
+func (p *PostgresPersistence) AddChannels(ctx context.Context, u User, names []string) ([]*Channel, error) {
+	created := make([]*Channel, 0)
+
+	for _, label := range names {
+		if label == "" {
+			continue
+		}
+		slug := strings.ToLower(strings.Replace(label, " ", "_", -1))
+		_, err := p.Pool.Exec(ctx, `insert into channel(user_id, slug, label) values($1, $2, $3)`,
+			u.Id, slug, label)
+		if err != nil {
+			log.Println("error creating channel", err)
+			continue
+		}
+		c, err := p.GetChannel(ctx, u, slug)
+		if err == nil {
+			created = append(created, c)
+		}
+	}
+	return created, nil
+}
+
+func (p *PostgresPersistence) GetChannel(ctx context.Context, u User, slug string) (*Channel, error) {
+	var id int
+	var label string
+	err := p.Pool.QueryRow(ctx,
+		`select id, label from channel where user_id = $1 AND slug = $2`, u.Id, slug).Scan(&id, &label)
+	if err != nil {
+		return nil, err
+	}
+	return &Channel{Id: id, User: &u, Slug: slug, Label: label}, nil
+}
+
+func (p *PostgresPersistence) GetChannelById(ctx context.Context, id int) (*Channel, error) {
+	var slug, label string
+	var userId int
+	err := p.Pool.QueryRow(ctx,
+		`select user_id, slug, label from channel where id = $1`, id).Scan(&userId, &slug, &label)
+	if err != nil {
+		return nil, err
+	}
+	u, err := p.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &Channel{Id: id, User: u, Slug: slug, Label: label}, nil
+}
+
+func (p *PostgresPersistence) GetPost(ctx context.Context, id int) (*Post, error) {
+	var body, uu string
+	var userId, posted int
+	err := p.Pool.QueryRow(ctx,
+		`select user_id, uuid, body, posted from post where id = $1`, id).
+		Scan(&userId, &uu, &body, &posted)
+	if err != nil {
+		log.Println("error querying by post id", err)
+		return nil, err
+	}
+
+	u, err := p.GetUserById(ctx, userId)
+	if err != nil {
+		log.Println("error getting post user", err)
+		return nil, err
+	}
+	return &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted}, nil
+}
+
+func (p *PostgresPersistence) GetAllPosts(ctx context.Context, limit int, offset int) ([]*Post, error) {
+	rows, err := p.Pool.Query(ctx, `select id, uuid, user_id, body, posted
+        from post order by posted desc limit $1 offset $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		var id, userId, posted int
+		var body, uu string
+		rows.Scan(&id, &uu, &userId, &body, &posted)
+		u, err := p.GetUserById(ctx, userId)
+		if err != nil {
+			continue
+		}
+		posts = append(posts, &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted})
+	}
+	return posts, nil
+}
+
+func (p *PostgresPersistence) GetAllUserPosts(ctx context.Context, u *User, limit int, offset int) ([]*Post, error) {
+	rows, err := p.Pool.Query(ctx, `select id, uuid, body, posted
+        from post where user_id = $1 order by posted desc limit $2 offset $3`, u.Id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		var id, posted int
+		var body, uu string
+		rows.Scan(&id, &uu, &body, &posted)
+		posts = append(posts, &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted})
+	}
+	return posts, nil
+}
+
+func (p *PostgresPersistence) AddPost(ctx context.Context, u User, body string, channels []*Channel) (*Post, error) {
+	if p.rateLimiter != nil && !p.rateLimiter.Allow(u.Id) {
+		return nil, ErrRateLimited
+	}
+
+	tx, err := p.Pool.Begin(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	u4, err := uuid.NewV4()
+	if err != nil {
+		log.Println("error:", err)
+		return nil, err
+	}
+
+	var id int
+	err = tx.QueryRow(ctx,
+		`insert into post(user_id, uuid, body, posted) values($1, $2, $3, $4) returning id`,
+		u.Id, u4.String(), body, time.Now().Unix()).Scan(&id)
+	if err != nil {
+		log.Println("error inserting post", err)
+		return nil, err
+	}
+	log.Println("post id", id)
+
+	for _, c := range channels {
+		_, err = tx.Exec(ctx, `insert into postchannel (post_id, channel_id) values ($1, $2)`,
+			id, c.Id)
+		if err != nil {
+			log.Println("error associating channel with post", err)
+		}
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return nil, err
+	}
+
+	post, err := p.GetPost(ctx, id)
+	if err != nil {
+		log.Println("error getting post", err)
+		return nil, err
+	}
+
+	if p.onPostCreated != nil {
+		p.onPostCreated(u, post)
+	}
+
+	return post, nil
+}
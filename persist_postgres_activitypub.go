@@ -0,0 +1,112 @@
+package main
+
+import (
+	"context"
+)
+
+func (p *PostgresPersistence) GetUserKeys(userId int) (*UserKeys, error) {
+	ctx := context.Background()
+	var pub, priv string
+	err := p.Pool.QueryRow(ctx,
+		`select public_key, private_key from user_keys where user_id = $1`, userId).Scan(&pub, &priv)
+	if err != nil {
+		return nil, err
+	}
+	return &UserKeys{UserId: userId, PublicKey: pub, PrivateKey: priv}, nil
+}
+
+func (p *PostgresPersistence) EnsureUserKeys(userId int) (*UserKeys, error) {
+	ctx := context.Background()
+	keys, err := p.GetUserKeys(userId)
+	if err == nil {
+		return keys, nil
+	}
+
+	keys, err = generateUserKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys.UserId = userId
+
+	_, err = p.Pool.Exec(ctx,
+		`insert into user_keys(user_id, public_key, private_key) values($1, $2, $3)`,
+		userId, keys.PublicKey, keys.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+func (p *PostgresPersistence) AddRemoteUser(r *RemoteUser) (int, error) {
+	ctx := context.Background()
+	var id int
+	err := p.Pool.QueryRow(ctx,
+		`select id from remote_users where actor_id = $1`, r.ActorId).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	err = p.Pool.QueryRow(ctx,
+		`insert into remote_users(actor_id, inbox, shared_inbox, handle, public_key)
+        values($1, $2, $3, $4, $5) returning id`,
+		r.ActorId, r.Inbox, r.SharedInbox, r.Handle, r.PublicKeyPem).Scan(&id)
+	return id, err
+}
+
+func (p *PostgresPersistence) AddFollower(userId, remoteUserId int) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`insert into followers(user_id, remote_user_id) values($1, $2)`, userId, remoteUserId)
+	return err
+}
+
+func (p *PostgresPersistence) RemoveFollower(userId, remoteUserId int) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`delete from followers where user_id = $1 and remote_user_id = $2`, userId, remoteUserId)
+	return err
+}
+
+func (p *PostgresPersistence) FollowerInboxes(userId int) ([]string, error) {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx, `select ru.shared_inbox, ru.inbox from followers f
+        join remote_users ru on ru.id = f.remote_user_id
+        where f.user_id = $1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	inboxes := make([]string, 0)
+	for rows.Next() {
+		var shared, inbox string
+		rows.Scan(&shared, &inbox)
+		target := inbox
+		if shared != "" {
+			target = shared
+		}
+		if !seen[target] {
+			seen[target] = true
+			inboxes = append(inboxes, target)
+		}
+	}
+	return inboxes, nil
+}
+
+func (p *PostgresPersistence) AddRemotePost(actorId, objectId, body string) (int, error) {
+	ctx := context.Background()
+	var id int
+	err := p.Pool.QueryRow(ctx,
+		`insert into remote_post(actor_id, object_id, body, posted) values($1, $2, $3, extract(epoch from now()))
+        returning id`, actorId, objectId, body).Scan(&id)
+	return id, err
+}
+
+// DeleteRemotePost removes the remote post with the given AP object id
+// (the Note's own id, not its author's actor id -- a Delete{Note}
+// activity only tells us which note went away, not which of the
+// actor's other posts should survive).
+func (p *PostgresPersistence) DeleteRemotePost(objectId string) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`delete from remote_post where object_id = $1`, objectId)
+	return err
+}
@@ -0,0 +1,34 @@
+package main
+
+import "context"
+
+func (p *PostgresPersistence) AddAttachment(postId int, key, contentType string, size int64) (*Attachment, error) {
+	ctx := context.Background()
+	var id int
+	err := p.Pool.QueryRow(ctx,
+		`insert into attachments(post_id, key, content_type, size) values($1, $2, $3, $4) returning id`,
+		postId, key, contentType, size).Scan(&id)
+	if err != nil {
+		return nil, err
+	}
+	return &Attachment{Id: id, PostId: postId, Key: key, ContentType: contentType, Size: size}, nil
+}
+
+func (p *PostgresPersistence) GetAttachmentsForPost(postId int) ([]*Attachment, error) {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx,
+		`select id, key, content_type, size from attachments where post_id = $1`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		a.PostId = postId
+		rows.Scan(&a.Id, &a.Key, &a.ContentType, &a.Size)
+		attachments = append(attachments, &a)
+	}
+	return attachments, nil
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+func (p *PostgresPersistence) seedDefaultRoles(ctx context.Context) error {
+	for _, r := range defaultRoles {
+		perms, err := json.Marshal(r.Permissions)
+		if err != nil {
+			return err
+		}
+		if _, err := p.Pool.Exec(ctx,
+			`insert into roles(name, permissions_json) values($1, $2)
+            on conflict (name) do nothing`,
+			r.Name, string(perms)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *PostgresPersistence) HasPermission(userId int, perm string) bool {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx, `select r.permissions_json from roles r
+        join user_roles ur on ur.role_id = r.id
+        where ur.user_id = $1`, userId)
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permsJSON string
+		if err := rows.Scan(&permsJSON); err != nil {
+			continue
+		}
+		var perms []string
+		if err := json.Unmarshal([]byte(permsJSON), &perms); err != nil {
+			continue
+		}
+		for _, p := range perms {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AssignRole replaces userId's role assignments with roleName, so a
+// moderator demoted back to "user" actually loses moderator permissions
+// rather than accumulating a second role.
+func (p *PostgresPersistence) AssignRole(userId int, roleName string) error {
+	ctx := context.Background()
+	var roleId int
+	err := p.Pool.QueryRow(ctx, `select id from roles where name = $1`, roleName).Scan(&roleId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	if _, err := tx.Exec(ctx, `delete from user_roles where user_id = $1`, userId); err != nil {
+		return err
+	}
+	if _, err := tx.Exec(ctx,
+		`insert into user_roles(user_id, role_id) values($1, $2)`, userId, roleId); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// DeletePost removes a post and everything that references it so the
+// delete doesn't fail under foreign keys declared in the migrations
+// (postchannel, attachments, archived_urls all reference post(id)).
+func (p *PostgresPersistence) DeletePost(postId int) error {
+	ctx := context.Background()
+	tx, err := p.Pool.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback(ctx)
+
+	for _, table := range []string{"postchannel", "attachments", "archived_urls"} {
+		if _, err := tx.Exec(ctx, `delete from `+table+` where post_id = $1`, postId); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.Exec(ctx, `delete from post where id = $1`, postId); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+func (p *PostgresPersistence) SetUserBanned(userId int, banned bool) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`update users set banned = $1 where id = $2`, banned, userId)
+	return err
+}
+
+func (p *PostgresPersistence) LogAdminAction(actorId int, action, targetType string, targetId int, details map[string]interface{}, ip string) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`insert into admin_log(actor_id, action, target_type, target_id, details_json, ip, ts)
+        values($1, $2, $3, $4, $5, $6, $7)`,
+		actorId, action, targetType, targetId, marshalDetails(details), ip, time.Now().Unix())
+	return err
+}
+
+func (p *PostgresPersistence) AdminLog(limit, offset int) ([]*AdminLogEntry, error) {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx, `select id, actor_id, action, target_type, target_id,
+        details_json, ip, ts from admin_log order by ts desc limit $1 offset $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AdminLogEntry, 0)
+	for rows.Next() {
+		var e AdminLogEntry
+		rows.Scan(&e.Id, &e.ActorId, &e.Action, &e.TargetType, &e.TargetId, &e.Details, &e.IP, &e.Ts)
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (p *PostgresPersistence) CreateApiToken(userId int, label string) (string, *ApiToken, error) {
+	token, err := newApiToken()
+	if err != nil {
+		return "", nil, err
+	}
+	hash := hashToken(token)
+	now := time.Now().Unix()
+
+	var id int
+	err = p.Pool.QueryRow(context.Background(),
+		`insert into api_tokens(user_id, token_hash, label, created, revoked) values($1, $2, $3, $4, false)
+        returning id`, userId, hash, label, now).Scan(&id)
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &ApiToken{Id: id, UserId: userId, Label: label, Created: int(now)}, nil
+}
+
+func (p *PostgresPersistence) UserFromToken(token string) (*User, error) {
+	ctx := context.Background()
+	hash := hashToken(token)
+
+	var userId int
+	var revoked bool
+	err := p.Pool.QueryRow(ctx,
+		`select user_id, revoked from api_tokens where token_hash = $1`, hash).Scan(&userId, &revoked)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token revoked")
+	}
+	return p.GetUserById(ctx, userId)
+}
+
+func (p *PostgresPersistence) TouchApiToken(tokenHash string) {
+	p.Pool.Exec(context.Background(),
+		`update api_tokens set last_used = $1 where token_hash = $2`, time.Now().Unix(), tokenHash)
+}
+
+func (p *PostgresPersistence) UserApiTokens(userId int) ([]*ApiToken, error) {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx,
+		`select id, label, created, last_used, revoked from api_tokens where user_id = $1`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*ApiToken, 0)
+	for rows.Next() {
+		var t ApiToken
+		var lastUsed *int64
+		t.UserId = userId
+		rows.Scan(&t.Id, &t.Label, &t.Created, &lastUsed, &t.Revoked)
+		if lastUsed != nil {
+			t.LastUsed = int(*lastUsed)
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+func (p *PostgresPersistence) RevokeApiToken(userId, tokenId int) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`update api_tokens set revoked = true where id = $1 and user_id = $2`, tokenId, userId)
+	return err
+}
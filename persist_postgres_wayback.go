@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+func (p *PostgresPersistence) AddArchivalRequest(postId int, url string) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`insert into archived_urls(post_id, url, status, first_seen) values($1, $2, 'pending', $3)`,
+		postId, url, time.Now().Unix())
+	return err
+}
+
+func (p *PostgresPersistence) GetArchivedURL(url string) (*ArchivedURL, error) {
+	ctx := context.Background()
+	var a ArchivedURL
+	var waybackURL *string
+	var lastChecked *int64
+	err := p.Pool.QueryRow(ctx,
+		`select id, post_id, url, wayback_url, status, first_seen, last_checked
+        from archived_urls where url = $1 order by id desc limit 1`, url).
+		Scan(&a.Id, &a.PostId, &a.URL, &waybackURL, &a.Status, &a.FirstSeen, &lastChecked)
+	if err != nil {
+		return nil, err
+	}
+	if waybackURL != nil {
+		a.WaybackURL = *waybackURL
+	}
+	if lastChecked != nil {
+		a.LastChecked = int(*lastChecked)
+	}
+	return &a, nil
+}
+
+func (p *PostgresPersistence) PendingArchivedURLs(limit int) ([]*ArchivedURL, error) {
+	ctx := context.Background()
+	retryCutoff := time.Now().Add(-waybackErrorRetryAfter).Unix()
+	rows, err := p.Pool.Query(ctx,
+		`select id, post_id, url, status, first_seen from archived_urls
+        where status = 'pending' or (status = 'error' and last_checked < $1)
+        order by first_seen asc limit $2`, retryCutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]*ArchivedURL, 0)
+	for rows.Next() {
+		var a ArchivedURL
+		rows.Scan(&a.Id, &a.PostId, &a.URL, &a.Status, &a.FirstSeen)
+		urls = append(urls, &a)
+	}
+	return urls, nil
+}
+
+func (p *PostgresPersistence) MarkArchivalStatus(id int, status string, waybackURL string) error {
+	_, err := p.Pool.Exec(context.Background(),
+		`update archived_urls set status = $1, wayback_url = $2, last_checked = $3 where id = $4`,
+		status, waybackURL, time.Now().Unix(), id)
+	return err
+}
+
+func (p *PostgresPersistence) GetAllArchivedURLs(limit int, offset int) ([]*ArchivedURL, error) {
+	ctx := context.Background()
+	rows, err := p.Pool.Query(ctx,
+		`select id, post_id, url, wayback_url, status, first_seen, last_checked
+        from archived_urls order by first_seen desc limit $1 offset $2`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]*ArchivedURL, 0)
+	for rows.Next() {
+		var a ArchivedURL
+		var waybackURL *string
+		var lastChecked *int64
+		rows.Scan(&a.Id, &a.PostId, &a.URL, &waybackURL, &a.Status, &a.FirstSeen, &lastChecked)
+		if waybackURL != nil {
+			a.WaybackURL = *waybackURL
+		}
+		if lastChecked != nil {
+			a.LastChecked = int(*lastChecked)
+		}
+		urls = append(urls, &a)
+	}
+	return urls, nil
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+	"github.com/nu7hatch/gouuid"
+)
+
+//go:embed migrations/sqlite/*.sql
+var sqliteMigrations embed.FS
+
+type SqlitePersistence struct {
+	Database      *sql.DB
+	onPostCreated func(u User, p *Post)
+	rateLimiter   *postRateLimiter
+}
+
+func NewSqlitePersistence(dbfile string) *SqlitePersistence {
+	db, err := sql.Open("sqlite3", dbfile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	p := &SqlitePersistence{Database: db}
+	if err := p.migrate(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	if err := p.seedDefaultRoles(context.Background()); err != nil {
+		log.Fatal(err)
+	}
+	perMinute, _ := strconv.Atoi(os.Getenv("FINCH_POST_RATE_PER_MIN"))
+	p.rateLimiter = newPostRateLimiter(perMinute)
+	return p
+}
+
+func (p *SqlitePersistence) migrate(ctx context.Context) error {
+	if _, err := p.Database.ExecContext(ctx, `create table if not exists schema_migrations (
+        version text primary key,
+        applied_at integer
+    )`); err != nil {
+		return err
+	}
+
+	files, err := sqliteMigrations.ReadDir("migrations/sqlite")
+	if err != nil {
+		return err
+	}
+	contents := make(map[string][]byte, len(files))
+	for _, f := range files {
+		b, err := sqliteMigrations.ReadFile("migrations/sqlite/" + f.Name())
+		if err != nil {
+			return err
+		}
+		contents[f.Name()] = b
+	}
+
+	return runMigrations(ctx, migrationRunner{
+		exec: func(ctx context.Context, query string) error {
+			_, err := p.Database.ExecContext(ctx, query)
+			return err
+		},
+		applied: func(ctx context.Context, version string) (bool, error) {
+			var v string
+			err := p.Database.QueryRowContext(ctx,
+				`select version from schema_migrations where version = ?`, version).Scan(&v)
+			if err == sql.ErrNoRows {
+				return false, nil
+			}
+			return err == nil, err
+		},
+		record: func(ctx context.Context, version string) error {
+			_, err := p.Database.ExecContext(ctx,
+				`insert into schema_migrations(version, applied_at) values(?, ?)`,
+				version, time.Now().Unix())
+			return err
+		},
+	}, contents)
+}
+
+func (p *SqlitePersistence) Close() {
+	p.Database.Close()
+}
+
+func (p *SqlitePersistence) SetOnPostCreated(fn func(u User, p *Post)) {
+	p.onPostCreated = fn
+}
+
+func (p *SqlitePersistence) GetUser(ctx context.Context, username string) (*User, bool) {
+	var id int
+	var password string
+	var banned bool
+	err := p.Database.QueryRowContext(ctx,
+		"select id, password, banned from users where username = ?", username).Scan(&id, &password, &banned)
+	if err != nil {
+		return nil, false
+	}
+	return &User{Id: id, Username: username, Password: []byte(password), Banned: banned}, true
+}
+
+func (p *SqlitePersistence) GetUserById(ctx context.Context, id int) (*User, error) {
+	var username, password string
+	var banned bool
+	err := p.Database.QueryRowContext(ctx,
+		`select username, password, banned from users where id = ?`, id).Scan(&username, &password, &banned)
+	if err != nil {
+		return nil, err
+	}
+	return &User{Id: id, Username: username, Password: []byte(password), Banned: banned}, nil
+}
+
+func (p *SqlitePersistence) CreateUser(ctx context.Context, username, password string) (*User, error) {
+	var user User
+	user.Username = username
+	encpassword := user.SetPassword(password)
+
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	_, err = tx.ExecContext(ctx, "insert into users(username, password) values(?, ?)",
+		username, encpassword)
+	if err != nil {
+		tx.Rollback()
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	u, _ := p.GetUser(ctx, username)
+	if u != nil {
+		if _, err := p.EnsureUserKeys(u.Id); err != nil {
+			log.Println("error generating activitypub keys for", username, err)
+		}
+		if err := p.AssignRole(u.Id, "user"); err != nil {
+			log.Println("error assigning default role to", username, err)
+		}
+	}
+	return u, nil
+}
+
+func (p *SqlitePersistence) UserChannels(ctx context.Context, u User) ([]*Channel, error) {
+	rows, err := p.Database.QueryContext(ctx,
+		`select id, slug, label from channel where user_id = ?`, u.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	channels := make([]*Channel, 0)
+	for rows.Next() {
+		var id int
+		var slug, label string
+		rows.Scan(&id, &slug, &label)
+		channels = append(channels, &Channel{Id: id, Slug: slug, Label: label})
+	}
+	return channels, nil
+}
+
+func (p *SqlitePersistence) AddChannels(ctx context.Context, u User, names []string) ([]*Channel, error) {
+	created := make([]*Channel, 0)
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, label := range names {
+		if label == "" {
+			continue
+		}
+		slug := strings.ToLower(strings.Replace(label, " ", "_", -1))
+		res, err := tx.ExecContext(ctx, `insert into channel(user_id, slug, label) values(?, ?, ?)`,
+			u.Id, slug, label)
+		if err != nil {
+			log.Println("error creating channel", err)
+			continue
+		}
+		id, err := res.LastInsertId()
+		if err == nil {
+			created = append(created, &Channel{Id: int(id), User: &u, Slug: slug, Label: label})
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func (p *SqlitePersistence) GetChannel(ctx context.Context, u User, slug string) (*Channel, error) {
+	var id int
+	var label string
+	err := p.Database.QueryRowContext(ctx,
+		`select id, label from channel where user_id = ? AND slug = ?`, u.Id, slug).Scan(&id, &label)
+	if err != nil {
+		return nil, err
+	}
+	return &Channel{Id: id, User: &u, Slug: slug, Label: label}, nil
+}
+
+func (p *SqlitePersistence) GetChannelById(ctx context.Context, id int) (*Channel, error) {
+	var slug, label string
+	var userId int
+	err := p.Database.QueryRowContext(ctx,
+		`select user_id, slug, label from channel where id = ?`, id).Scan(&userId, &slug, &label)
+	if err != nil {
+		return nil, err
+	}
+	u, err := p.GetUserById(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	return &Channel{Id: id, User: u, Slug: slug, Label: label}, nil
+}
+
+func (p *SqlitePersistence) GetPost(ctx context.Context, id int) (*Post, error) {
+	var body, uu string
+	var userId, posted int
+	err := p.Database.QueryRowContext(ctx,
+		`select user_id, uuid, body, posted from post where id = ?`, id).
+		Scan(&userId, &uu, &body, &posted)
+	if err != nil {
+		log.Println("error querying by post id", err)
+		return nil, err
+	}
+
+	u, err := p.GetUserById(ctx, userId)
+	if err != nil {
+		log.Println("error getting post user", err)
+		return nil, err
+	}
+	// TODO: also get channels
+	return &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted}, nil
+}
+
+func (p *SqlitePersistence) GetAllPosts(ctx context.Context, limit int, offset int) ([]*Post, error) {
+	rows, err := p.Database.QueryContext(ctx, `select id, uuid, user_id, body, posted
+        from post order by posted desc limit ? offset ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		var id, userId, posted int
+		var body, uu string
+		rows.Scan(&id, &uu, &userId, &body, &posted)
+		u, err := p.GetUserById(ctx, userId)
+		if err != nil {
+			continue
+		}
+		posts = append(posts, &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted})
+	}
+	return posts, nil
+}
+
+func (p *SqlitePersistence) GetAllUserPosts(ctx context.Context, u *User, limit int, offset int) ([]*Post, error) {
+	rows, err := p.Database.QueryContext(ctx, `select id, uuid, body, posted
+        from post where user_id = ? order by posted desc limit ? offset ?`, u.Id, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	posts := make([]*Post, 0)
+	for rows.Next() {
+		var id, posted int
+		var body, uu string
+		rows.Scan(&id, &uu, &body, &posted)
+		posts = append(posts, &Post{Id: id, UUID: uu, User: u, Body: body, Posted: posted})
+	}
+	return posts, nil
+}
+
+func (p *SqlitePersistence) AddPost(ctx context.Context, u User, body string, channels []*Channel) (*Post, error) {
+	if p.rateLimiter != nil && !p.rateLimiter.Allow(u.Id) {
+		return nil, ErrRateLimited
+	}
+
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	u4, err := uuid.NewV4()
+	if err != nil {
+		fmt.Println("error:", err)
+		return nil, err
+	}
+
+	r, err := tx.ExecContext(ctx, `insert into post(user_id, uuid, body, posted) values(?, ?, ?, ?)`,
+		u.Id, u4.String(), body, time.Now().Unix())
+	if err != nil {
+		log.Println("error inserting post", err)
+		tx.Rollback()
+		return nil, err
+	}
+
+	id, err := r.LastInsertId()
+	if err != nil {
+		log.Println("error getting last inserted id", err)
+		return nil, err
+	}
+	log.Println("post id", int(id))
+
+	for _, c := range channels {
+		_, err = tx.ExecContext(ctx, `insert into postchannel (post_id, channel_id) values (?, ?)`,
+			int(id), c.Id)
+		if err != nil {
+			log.Println("error associating channel with post", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	post, err := p.GetPost(ctx, int(id))
+	if err != nil {
+		log.Println("error getting post", err)
+		return nil, err
+	}
+
+	if p.onPostCreated != nil {
+		p.onPostCreated(u, post)
+	}
+
+	return post, nil
+}
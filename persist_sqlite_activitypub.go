@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+)
+
+func (p *SqlitePersistence) GetUserKeys(userId int) (*UserKeys, error) {
+	ctx := context.Background()
+	var pub, priv string
+	err := p.Database.QueryRowContext(ctx,
+		`select public_key, private_key from user_keys where user_id = ?`, userId).Scan(&pub, &priv)
+	if err != nil {
+		return nil, err
+	}
+	return &UserKeys{UserId: userId, PublicKey: pub, PrivateKey: priv}, nil
+}
+
+// EnsureUserKeys returns the user's keypair, generating and storing one
+// the first time it's needed (e.g. right after registration).
+func (p *SqlitePersistence) EnsureUserKeys(userId int) (*UserKeys, error) {
+	ctx := context.Background()
+	keys, err := p.GetUserKeys(userId)
+	if err == nil {
+		return keys, nil
+	}
+
+	keys, err = generateUserKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys.UserId = userId
+
+	_, err = p.Database.ExecContext(ctx,
+		`insert into user_keys(user_id, public_key, private_key) values(?, ?, ?)`,
+		userId, keys.PublicKey, keys.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return keys, nil
+}
+
+// AddRemoteUser upserts a cached remote actor and returns its local id.
+func (p *SqlitePersistence) AddRemoteUser(r *RemoteUser) (int, error) {
+	ctx := context.Background()
+	var id int
+	err := p.Database.QueryRowContext(ctx,
+		`select id from remote_users where actor_id = ?`, r.ActorId).Scan(&id)
+	if err == nil {
+		return id, nil
+	}
+
+	res, err := p.Database.ExecContext(ctx,
+		`insert into remote_users(actor_id, inbox, shared_inbox, handle, public_key)
+        values(?, ?, ?, ?, ?)`, r.ActorId, r.Inbox, r.SharedInbox, r.Handle, r.PublicKeyPem)
+	if err != nil {
+		return 0, err
+	}
+	lastId, err := res.LastInsertId()
+	return int(lastId), err
+}
+
+func (p *SqlitePersistence) AddFollower(userId, remoteUserId int) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`insert into followers(user_id, remote_user_id) values(?, ?)`, userId, remoteUserId)
+	return err
+}
+
+func (p *SqlitePersistence) RemoveFollower(userId, remoteUserId int) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`delete from followers where user_id = ? and remote_user_id = ?`, userId, remoteUserId)
+	return err
+}
+
+// FollowerInboxes returns the distinct delivery targets for a user's
+// followers, preferring each remote server's sharedInbox when known.
+func (p *SqlitePersistence) FollowerInboxes(userId int) ([]string, error) {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx, `select ru.shared_inbox, ru.inbox from followers f
+        join remote_users ru on ru.id = f.remote_user_id
+        where f.user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	seen := make(map[string]bool)
+	inboxes := make([]string, 0)
+	for rows.Next() {
+		var shared, inbox string
+		rows.Scan(&shared, &inbox)
+		target := inbox
+		if shared != "" {
+			target = shared
+		}
+		if !seen[target] {
+			seen[target] = true
+			inboxes = append(inboxes, target)
+		}
+	}
+	return inboxes, nil
+}
+
+func (p *SqlitePersistence) AddRemotePost(actorId, objectId, body string) (int, error) {
+	res, err := p.Database.ExecContext(context.Background(),
+		`insert into remote_post(actor_id, object_id, body, posted) values(?, ?, ?, strftime('%s','now'))`,
+		actorId, objectId, body)
+	if err != nil {
+		return 0, err
+	}
+	id, err := res.LastInsertId()
+	return int(id), err
+}
+
+// DeleteRemotePost removes the remote post with the given AP object id
+// (the Note's own id, not its author's actor id -- a Delete{Note}
+// activity only tells us which note went away, not which of the
+// actor's other posts should survive).
+func (p *SqlitePersistence) DeleteRemotePost(objectId string) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`delete from remote_post where object_id = ?`, objectId)
+	return err
+}
@@ -0,0 +1,36 @@
+package main
+
+import "context"
+
+func (p *SqlitePersistence) AddAttachment(postId int, key, contentType string, size int64) (*Attachment, error) {
+	res, err := p.Database.ExecContext(context.Background(),
+		`insert into attachments(post_id, key, content_type, size) values(?, ?, ?, ?)`,
+		postId, key, contentType, size)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	return &Attachment{Id: int(id), PostId: postId, Key: key, ContentType: contentType, Size: size}, nil
+}
+
+func (p *SqlitePersistence) GetAttachmentsForPost(postId int) ([]*Attachment, error) {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx,
+		`select id, key, content_type, size from attachments where post_id = ?`, postId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attachments := make([]*Attachment, 0)
+	for rows.Next() {
+		var a Attachment
+		a.PostId = postId
+		rows.Scan(&a.Id, &a.Key, &a.ContentType, &a.Size)
+		attachments = append(attachments, &a)
+	}
+	return attachments, nil
+}
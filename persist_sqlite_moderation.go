@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+func (p *SqlitePersistence) seedDefaultRoles(ctx context.Context) error {
+	for _, r := range defaultRoles {
+		perms, err := json.Marshal(r.Permissions)
+		if err != nil {
+			return err
+		}
+		if _, err := p.Database.ExecContext(ctx,
+			`insert or ignore into roles(name, permissions_json) values(?, ?)`,
+			r.Name, string(perms)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (p *SqlitePersistence) HasPermission(userId int, perm string) bool {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx, `select r.permissions_json from roles r
+        join user_roles ur on ur.role_id = r.id
+        where ur.user_id = ?`, userId)
+	if err != nil {
+		return false
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var permsJSON string
+		if err := rows.Scan(&permsJSON); err != nil {
+			continue
+		}
+		var perms []string
+		if err := json.Unmarshal([]byte(permsJSON), &perms); err != nil {
+			continue
+		}
+		for _, p := range perms {
+			if p == perm {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// AssignRole replaces userId's role assignments with roleName, so a
+// moderator demoted back to "user" actually loses moderator permissions
+// rather than accumulating a second role.
+func (p *SqlitePersistence) AssignRole(userId int, roleName string) error {
+	ctx := context.Background()
+	var roleId int
+	err := p.Database.QueryRowContext(ctx, `select id from roles where name = ?`, roleName).Scan(&roleId)
+	if err != nil {
+		return err
+	}
+
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, `delete from user_roles where user_id = ?`, userId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if _, err := tx.ExecContext(ctx,
+		`insert into user_roles(user_id, role_id) values(?, ?)`, userId, roleId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+// DeletePost removes a post and everything that references it so the
+// delete doesn't fail (or, on SQLite, silently leave orphans) under
+// backends that enforce the foreign keys declared in the migrations.
+func (p *SqlitePersistence) DeletePost(postId int) error {
+	ctx := context.Background()
+	tx, err := p.Database.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"postchannel", "attachments", "archived_urls"} {
+		if _, err := tx.ExecContext(ctx, `delete from `+table+` where post_id = ?`, postId); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `delete from post where id = ?`, postId); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (p *SqlitePersistence) SetUserBanned(userId int, banned bool) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`update users set banned = ? where id = ?`, banned, userId)
+	return err
+}
+
+func (p *SqlitePersistence) LogAdminAction(actorId int, action, targetType string, targetId int, details map[string]interface{}, ip string) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`insert into admin_log(actor_id, action, target_type, target_id, details_json, ip, ts)
+        values(?, ?, ?, ?, ?, ?, ?)`,
+		actorId, action, targetType, targetId, marshalDetails(details), ip, time.Now().Unix())
+	return err
+}
+
+func (p *SqlitePersistence) AdminLog(limit, offset int) ([]*AdminLogEntry, error) {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx, `select id, actor_id, action, target_type, target_id,
+        details_json, ip, ts from admin_log order by ts desc limit ? offset ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	entries := make([]*AdminLogEntry, 0)
+	for rows.Next() {
+		var e AdminLogEntry
+		rows.Scan(&e.Id, &e.ActorId, &e.Action, &e.TargetType, &e.TargetId, &e.Details, &e.IP, &e.Ts)
+		entries = append(entries, &e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+func (p *SqlitePersistence) CreateApiToken(userId int, label string) (string, *ApiToken, error) {
+	token, err := newApiToken()
+	if err != nil {
+		return "", nil, err
+	}
+	hash := hashToken(token)
+	now := time.Now().Unix()
+
+	res, err := p.Database.ExecContext(context.Background(),
+		`insert into api_tokens(user_id, token_hash, label, created, revoked) values(?, ?, ?, ?, 0)`,
+		userId, hash, label, now)
+	if err != nil {
+		return "", nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return "", nil, err
+	}
+	return token, &ApiToken{Id: int(id), UserId: userId, Label: label, Created: int(now)}, nil
+}
+
+func (p *SqlitePersistence) UserFromToken(token string) (*User, error) {
+	ctx := context.Background()
+	hash := hashToken(token)
+
+	var userId int
+	var revoked bool
+	err := p.Database.QueryRowContext(ctx,
+		`select user_id, revoked from api_tokens where token_hash = ?`, hash).Scan(&userId, &revoked)
+	if err != nil {
+		return nil, err
+	}
+	if revoked {
+		return nil, errors.New("token revoked")
+	}
+	return p.GetUserById(ctx, userId)
+}
+
+func (p *SqlitePersistence) TouchApiToken(tokenHash string) {
+	p.Database.ExecContext(context.Background(),
+		`update api_tokens set last_used = ? where token_hash = ?`, time.Now().Unix(), tokenHash)
+}
+
+func (p *SqlitePersistence) UserApiTokens(userId int) ([]*ApiToken, error) {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx,
+		`select id, label, created, last_used, revoked from api_tokens where user_id = ?`, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := make([]*ApiToken, 0)
+	for rows.Next() {
+		var t ApiToken
+		var lastUsed *int
+		t.UserId = userId
+		rows.Scan(&t.Id, &t.Label, &t.Created, &lastUsed, &t.Revoked)
+		if lastUsed != nil {
+			t.LastUsed = *lastUsed
+		}
+		tokens = append(tokens, &t)
+	}
+	return tokens, nil
+}
+
+func (p *SqlitePersistence) RevokeApiToken(userId, tokenId int) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`update api_tokens set revoked = 1 where id = ? and user_id = ?`, tokenId, userId)
+	return err
+}
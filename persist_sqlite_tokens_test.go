@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUserFromTokenRoundTrip(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	token, _, err := p.CreateApiToken(u.Id, "cli")
+	if err != nil {
+		t.Fatalf("CreateApiToken: %v", err)
+	}
+
+	got, err := p.UserFromToken(token)
+	if err != nil {
+		t.Fatalf("UserFromToken: %v", err)
+	}
+	if got.Id != u.Id {
+		t.Fatalf("UserFromToken returned user %d, want %d", got.Id, u.Id)
+	}
+
+	if err := p.RevokeApiToken(u.Id, mustTokenId(t, p, u.Id)); err != nil {
+		t.Fatalf("RevokeApiToken: %v", err)
+	}
+	if _, err := p.UserFromToken(token); err == nil {
+		t.Fatal("expected UserFromToken to fail for a revoked token")
+	}
+}
+
+func mustTokenId(t *testing.T, p *SqlitePersistence, userId int) int {
+	t.Helper()
+	tokens, err := p.UserApiTokens(userId)
+	if err != nil || len(tokens) == 0 {
+		t.Fatalf("UserApiTokens: %v", err)
+	}
+	return tokens[0].Id
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"time"
+)
+
+func (p *SqlitePersistence) AddArchivalRequest(postId int, url string) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`insert into archived_urls(post_id, url, status, first_seen) values(?, ?, 'pending', ?)`,
+		postId, url, time.Now().Unix())
+	return err
+}
+
+func (p *SqlitePersistence) GetArchivedURL(url string) (*ArchivedURL, error) {
+	ctx := context.Background()
+	var a ArchivedURL
+	var waybackURL *string
+	var lastChecked *int
+	err := p.Database.QueryRowContext(ctx,
+		`select id, post_id, url, wayback_url, status, first_seen, last_checked
+        from archived_urls where url = ? order by id desc limit 1`, url).
+		Scan(&a.Id, &a.PostId, &a.URL, &waybackURL, &a.Status, &a.FirstSeen, &lastChecked)
+	if err != nil {
+		return nil, err
+	}
+	if waybackURL != nil {
+		a.WaybackURL = *waybackURL
+	}
+	if lastChecked != nil {
+		a.LastChecked = *lastChecked
+	}
+	return &a, nil
+}
+
+func (p *SqlitePersistence) PendingArchivedURLs(limit int) ([]*ArchivedURL, error) {
+	ctx := context.Background()
+	retryCutoff := time.Now().Add(-waybackErrorRetryAfter).Unix()
+	rows, err := p.Database.QueryContext(ctx,
+		`select id, post_id, url, status, first_seen from archived_urls
+        where status = 'pending' or (status = 'error' and last_checked < ?)
+        order by first_seen asc limit ?`, retryCutoff, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]*ArchivedURL, 0)
+	for rows.Next() {
+		var a ArchivedURL
+		rows.Scan(&a.Id, &a.PostId, &a.URL, &a.Status, &a.FirstSeen)
+		urls = append(urls, &a)
+	}
+	return urls, nil
+}
+
+func (p *SqlitePersistence) MarkArchivalStatus(id int, status string, waybackURL string) error {
+	_, err := p.Database.ExecContext(context.Background(),
+		`update archived_urls set status = ?, wayback_url = ?, last_checked = ? where id = ?`,
+		status, waybackURL, time.Now().Unix(), id)
+	return err
+}
+
+func (p *SqlitePersistence) GetAllArchivedURLs(limit int, offset int) ([]*ArchivedURL, error) {
+	ctx := context.Background()
+	rows, err := p.Database.QueryContext(ctx,
+		`select id, post_id, url, wayback_url, status, first_seen, last_checked
+        from archived_urls order by first_seen desc limit ? offset ?`, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	urls := make([]*ArchivedURL, 0)
+	for rows.Next() {
+		var a ArchivedURL
+		var waybackURL *string
+		var lastChecked *int
+		rows.Scan(&a.Id, &a.PostId, &a.URL, &waybackURL, &a.Status, &a.FirstSeen, &lastChecked)
+		if waybackURL != nil {
+			a.WaybackURL = *waybackURL
+		}
+		if lastChecked != nil {
+			a.LastChecked = *lastChecked
+		}
+		urls = append(urls, &a)
+	}
+	return urls, nil
+}
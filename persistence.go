@@ -0,0 +1,99 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+)
+
+// Persistence is implemented by every storage backend Finch supports.
+// It stores federation state (keys, remote users, followers, remote
+// posts) but does no HTTP or delivery itself; callers that need to react
+// to a new post (e.g. ActivityPub delivery) register via
+// SetOnPostCreated.
+type Persistence interface {
+	GetUser(ctx context.Context, username string) (*User, bool)
+	GetUserById(ctx context.Context, id int) (*User, error)
+	CreateUser(ctx context.Context, username, password string) (*User, error)
+	UserChannels(ctx context.Context, u User) ([]*Channel, error)
+	AddChannels(ctx context.Context, u User, names []string) ([]*Channel, error)
+	GetChannel(ctx context.Context, u User, slug string) (*Channel, error)
+	GetChannelById(ctx context.Context, id int) (*Channel, error)
+	GetPost(ctx context.Context, id int) (*Post, error)
+	GetAllPosts(ctx context.Context, limit int, offset int) ([]*Post, error)
+	GetAllUserPosts(ctx context.Context, u *User, limit int, offset int) ([]*Post, error)
+	AddPost(ctx context.Context, u User, body string, channels []*Channel) (*Post, error)
+	Close()
+
+	// SetOnPostCreated registers a callback invoked after a post commits.
+	SetOnPostCreated(fn func(u User, p *Post))
+
+	// ActivityPub support, used by activitypub.go.
+	GetUserKeys(userId int) (*UserKeys, error)
+	EnsureUserKeys(userId int) (*UserKeys, error)
+	AddRemoteUser(r *RemoteUser) (int, error)
+	AddFollower(userId, remoteUserId int) error
+	RemoveFollower(userId, remoteUserId int) error
+	FollowerInboxes(userId int) ([]string, error)
+	AddRemotePost(actorId, objectId, body string) (int, error)
+	DeleteRemotePost(objectId string) error
+}
+
+type Channel struct {
+	Id    int    `json:"id"`
+	User  *User  `json:"-"`
+	Slug  string `json:"slug"`
+	Label string `json:"label"`
+}
+
+// newPersistence picks a backend based on FINCH_DB_DRIVER ("sqlite", the
+// default, or "postgres") and runs its pending migrations before
+// returning it.
+func newPersistence(dbfile string) Persistence {
+	driver := os.Getenv("FINCH_DB_DRIVER")
+	switch driver {
+	case "", "sqlite":
+		return NewSqlitePersistence(dbfile)
+	case "postgres":
+		return NewPostgresPersistence(os.Getenv("FINCH_DB_DSN"))
+	default:
+		log.Fatalf("unknown FINCH_DB_DRIVER %q", driver)
+		return nil
+	}
+}
+
+// migrationRunner applies numbered .sql files embedded from a migrations
+// directory, tracking what's already run in a schema_migrations table.
+type migrationRunner struct {
+	exec    func(ctx context.Context, query string) error
+	applied func(ctx context.Context, version string) (bool, error)
+	record  func(ctx context.Context, version string) error
+}
+
+func runMigrations(ctx context.Context, r migrationRunner, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		done, err := r.applied(ctx, name)
+		if err != nil {
+			return fmt.Errorf("checking migration %s: %w", name, err)
+		}
+		if done {
+			continue
+		}
+		if err := r.exec(ctx, string(files[name])); err != nil {
+			return fmt.Errorf("running migration %s: %w", name, err)
+		}
+		if err := r.record(ctx, name); err != nil {
+			return fmt.Errorf("recording migration %s: %w", name, err)
+		}
+		log.Println("applied migration", name)
+	}
+	return nil
+}
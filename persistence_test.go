@@ -0,0 +1,46 @@
+package main
+
+import (
+	"context"
+	"testing"
+)
+
+// TestSqlitePersistenceRespectsContextCancellation guards against the
+// Persistence interface quietly going back to context.Background()
+// internally: if a caller's context is already canceled, the query
+// should fail instead of running to completion.
+func TestSqlitePersistenceRespectsContextCancellation(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	if _, err := p.CreateUser(context.Background(), "alice", "hunter2"); err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, ok := p.GetUser(ctx, "alice"); ok {
+		t.Fatal("expected GetUser to fail once its context is canceled")
+	}
+}
+
+// TestUserChannelsReturnsErrorInsteadOfFatal guards against UserChannels
+// going back to log.Fatal on a query error: a canceled context should
+// come back as an error, not take the process down.
+func TestUserChannelsReturnsErrorInsteadOfFatal(t *testing.T) {
+	p := NewSqlitePersistence(":memory:")
+	defer p.Close()
+
+	u, err := p.CreateUser(context.Background(), "alice", "hunter2")
+	if err != nil {
+		t.Fatalf("CreateUser: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := p.UserChannels(ctx, *u); err == nil {
+		t.Fatal("expected UserChannels to return an error once its context is canceled")
+	}
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// postHandler serves the post submission form and a single post's page
+// under /post/, and creates a new post for the logged-in user on POST.
+func postHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	if r.Method == http.MethodPost {
+		createPostHandler(w, r, s)
+		return
+	}
+
+	idStr := strings.Trim(strings.TrimPrefix(r.URL.Path, "/post/"), "/")
+	if idStr == "" {
+		renderTemplate(w, "post_form", map[string]interface{}{})
+		return
+	}
+
+	id, err := strconv.Atoi(idStr)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	post, err := s.persistence.GetPost(r.Context(), id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var attachments []*Attachment
+	if ap, ok := s.persistence.(AttachingPersistence); ok {
+		attachments, err = ap.GetAttachmentsForPost(id)
+		if err != nil {
+			log.Println("error loading attachments for post", id, err)
+		}
+	}
+
+	renderTemplate(w, "post", map[string]interface{}{
+		"Post":        post,
+		"Attachments": attachments,
+	})
+}
+
+// createPostHandler handles the POST from the post form: it creates the
+// post, then links any files the user uploaded via uploadHandler
+// beforehand (carried in the "attachments" form field as a JSON array of
+// PendingAttachment) to the post that now has an id.
+func createPostHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	u := getCurrentUser(r, s)
+	if u == nil {
+		http.Redirect(w, r, "/login/", http.StatusFound)
+		return
+	}
+	if mp, ok := s.persistence.(ModerationPersistence); ok && !mp.HasPermission(u.Id, PermCreatePost) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+
+	var channels []*Channel
+	for _, slug := range strings.Fields(r.FormValue("channels")) {
+		if c, err := s.persistence.GetChannel(r.Context(), *u, slug); err == nil {
+			channels = append(channels, c)
+		}
+	}
+
+	post, err := s.persistence.AddPost(r.Context(), *u, r.FormValue("body"), channels)
+	if err == ErrRateLimited {
+		http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+		return
+	}
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+
+	linkPendingAttachments(s, post.Id, r.FormValue("attachments"))
+
+	http.Redirect(w, r, "/post/"+strconv.Itoa(post.Id), http.StatusFound)
+}
+
+// linkPendingAttachments creates the attachments table rows for files
+// uploaded alongside a post now that the post has an id.
+func linkPendingAttachments(s *site, postId int, attachmentsJSON string) {
+	if attachmentsJSON == "" {
+		return
+	}
+	ap, ok := s.persistence.(AttachingPersistence)
+	if !ok {
+		return
+	}
+
+	var pending []PendingAttachment
+	if err := json.Unmarshal([]byte(attachmentsJSON), &pending); err != nil {
+		log.Println("error parsing pending attachments", err)
+		return
+	}
+	for _, a := range pending {
+		if _, err := ap.AddAttachment(postId, a.Key, a.ContentType, a.Size); err != nil {
+			log.Println("error associating attachment", a.Key, "with post", postId, err)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// ArchivedURL tracks the Wayback Machine copy of a URL found in a post's
+// body, so the same URL isn't resubmitted to Save Page Now on every post
+// that links to it.
+type ArchivedURL struct {
+	Id          int
+	PostId      int
+	URL         string
+	WaybackURL  string
+	Status      string // "pending", "ok", "error"
+	FirstSeen   int
+	LastChecked int
+}
+
+// ArchivingPersistence is implemented by backends that support Wayback
+// Machine archival. It's a separate interface from Persistence so
+// backends that haven't added the archived_urls table yet still satisfy
+// Persistence.
+type ArchivingPersistence interface {
+	AddArchivalRequest(postId int, url string) error
+	GetArchivedURL(url string) (*ArchivedURL, error)
+	PendingArchivedURLs(limit int) ([]*ArchivedURL, error)
+	MarkArchivalStatus(id int, status string, waybackURL string) error
+	GetAllArchivedURLs(limit int, offset int) ([]*ArchivedURL, error)
+}
+
+var urlPattern = regexp.MustCompile(`https?://[^\s<>"']+`)
+
+// defaultArchivalTTLDays is how long a previously archived URL is
+// considered fresh enough to skip re-submitting, if FINCH_WAYBACK_TTL_DAYS
+// isn't set.
+const defaultArchivalTTLDays = 30
+
+// archivalTTL reads FINCH_WAYBACK_TTL_DAYS so operators can tune how
+// often already-archived URLs get resubmitted without a code change.
+func archivalTTL() time.Duration {
+	days := defaultArchivalTTLDays
+	if v := os.Getenv("FINCH_WAYBACK_TTL_DAYS"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			days = n
+		}
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// extractURLs returns the distinct URLs referenced in a post body.
+func extractURLs(body string) []string {
+	seen := make(map[string]bool)
+	urls := make([]string, 0)
+	for _, u := range urlPattern.FindAllString(body, -1) {
+		if !seen[u] {
+			seen[u] = true
+			urls = append(urls, u)
+		}
+	}
+	return urls
+}
+
+// enqueueArchival records any URLs in a new post that aren't already
+// archived and fresh, so the wayback worker picks them up.
+func enqueueArchival(p Persistence, post *Post) {
+	ap, ok := p.(ArchivingPersistence)
+	if !ok {
+		return
+	}
+	for _, u := range extractURLs(post.Body) {
+		existing, err := ap.GetArchivedURL(u)
+		if err == nil && existing.Status == "ok" &&
+			time.Now().Unix()-int64(existing.LastChecked) < int64(archivalTTL().Seconds()) {
+			continue
+		}
+		if err := ap.AddArchivalRequest(post.Id, u); err != nil {
+			log.Println("error queueing archival for", u, err)
+		}
+	}
+}
+
+type spn2Response struct {
+	JobId   string `json:"job_id"`
+	URL     string `json:"url"`
+	Message string `json:"message"`
+}
+
+type spn2Status struct {
+	Status         string `json:"status"`
+	OriginalURL    string `json:"original_url"`
+	Timestamp      string `json:"timestamp"`
+	ResourceSnapId string `json:"resource_snapshot_id"`
+}
+
+func spn2AuthHeader() string {
+	token := os.Getenv("FINCH_WAYBACK_TOKEN")
+	if token == "" {
+		return ""
+	}
+	return "LOW " + token
+}
+
+func submitToWayback(target string) (jobId string, err error) {
+	form := url.Values{"url": {target}}
+	req, err := http.NewRequest("POST", "https://web.archive.org/save/"+target,
+		bytes.NewBufferString(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if auth := spn2AuthHeader(); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	var sr spn2Response
+	if err := json.NewDecoder(resp.Body).Decode(&sr); err != nil {
+		return "", err
+	}
+	if sr.JobId == "" {
+		return "", fmt.Errorf("spn2 did not return a job id: %s", sr.Message)
+	}
+	return sr.JobId, nil
+}
+
+func pollWaybackJob(jobId string) (*spn2Status, error) {
+	req, err := http.NewRequest("GET", "https://web.archive.org/save/status/"+jobId, nil)
+	if err != nil {
+		return nil, err
+	}
+	if auth := spn2AuthHeader(); auth != "" {
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var st spn2Status
+	if err := json.NewDecoder(resp.Body).Decode(&st); err != nil {
+		return nil, err
+	}
+	return &st, nil
+}
+
+const waybackPollInterval = 5 * time.Second
+const waybackWorkerInterval = 30 * time.Second
+const waybackBatchSize = 20
+
+// waybackErrorRetryAfter is how long PendingArchivedURLs waits before
+// handing a URL that previously errored back to the worker, so a
+// transient SPN2 failure or rate limit doesn't retire it forever.
+const waybackErrorRetryAfter = 1 * time.Hour
+
+// startWaybackWorker periodically archives pending URLs via the Save
+// Page Now 2 API, one batch at a time.
+func startWaybackWorker(p Persistence) {
+	ap, ok := p.(ArchivingPersistence)
+	if !ok {
+		return
+	}
+	go func() {
+		for {
+			pending, err := ap.PendingArchivedURLs(waybackBatchSize)
+			if err != nil {
+				log.Println("error listing pending archival URLs", err)
+				time.Sleep(waybackWorkerInterval)
+				continue
+			}
+			for _, a := range pending {
+				archiveOne(ap, a)
+			}
+			time.Sleep(waybackWorkerInterval)
+		}
+	}()
+}
+
+func archiveOne(ap ArchivingPersistence, a *ArchivedURL) {
+	jobId, err := submitToWayback(a.URL)
+	if err != nil {
+		log.Println("error submitting to wayback", a.URL, err)
+		ap.MarkArchivalStatus(a.Id, "error", "")
+		return
+	}
+
+	for i := 0; i < 12; i++ {
+		time.Sleep(waybackPollInterval)
+		st, err := pollWaybackJob(jobId)
+		if err != nil {
+			log.Println("error polling wayback job", jobId, err)
+			continue
+		}
+		switch st.Status {
+		case "success":
+			waybackURL := fmt.Sprintf("https://web.archive.org/web/%s/%s", st.Timestamp, a.URL)
+			ap.MarkArchivalStatus(a.Id, "ok", waybackURL)
+			return
+		case "error":
+			ap.MarkArchivalStatus(a.Id, "error", "")
+			return
+		}
+	}
+	log.Println("gave up waiting for wayback job", jobId, "on", a.URL)
+	ap.MarkArchivalStatus(a.Id, "error", "")
+}
+
+// archiveIndexHandler lists processed URLs, paginated the same way
+// GetAllPosts is.
+func archiveIndexHandler(w http.ResponseWriter, r *http.Request, s *site) {
+	ap, ok := s.persistence.(ArchivingPersistence)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+	page := 0
+	if v := r.URL.Query().Get("page"); v != "" {
+		fmt.Sscanf(v, "%d", &page)
+	}
+	urls, err := ap.GetAllArchivedURLs(s.itemsPerPage, page*s.itemsPerPage)
+	if err != nil {
+		http.Error(w, "internal error", http.StatusInternalServerError)
+		return
+	}
+	renderTemplate(w, "archive_index", map[string]interface{}{
+		"URLs": urls,
+		"Page": page,
+	})
+}